@@ -0,0 +1,184 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// dockerArchiveManifest is an entry of the manifest.json of a docker save archive.
+type dockerArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+	// LayerSources describes the layers left out of the archive, which are the foreign ones
+	LayerSources map[string]ocispec.Descriptor
+}
+
+// parseInput splits an --input value into its transport and location, using the transport names of
+// skopeo. A lone - is a docker save stream on STDIN.
+func parseInput(input string) (transport string, location string, err error) {
+	if input == "-" {
+		return "docker-archive", "-", nil
+	}
+	transport, location, ok := strings.Cut(input, ":")
+	if !ok || location == "" {
+		return "", "", fmt.Errorf("the input %s has no transport - use e.g. docker://ghcr.io/org/app:1.0, docker-daemon:app:1.0 or docker-archive:/path/to/image.tar", input)
+	}
+	switch transport {
+	case "docker":
+		location, ok = strings.CutPrefix(location, "//")
+		if !ok || location == "" {
+			return "", "", fmt.Errorf("the input %s is not a registry reference - use docker://registry/repository:tag", input)
+		}
+	case "docker-daemon", "docker-archive", "oci", "dir":
+	default:
+		return "", "", fmt.Errorf("the input transport %s is not supported - use docker, docker-daemon, docker-archive, oci or dir", transport)
+	}
+	return transport, location, nil
+}
+
+// readArchiveFiles returns the contents of the named files of a tar archive, which may be gzip
+// compressed. Names are compared after cleaning, so ./manifest.json matches manifest.json.
+func readArchiveFiles(archivePath string, names []string) (files map[string][]byte, err error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the archive: %w", err)
+	}
+	defer f.Close()
+	buffered := bufio.NewReader(f)
+	var r io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress the archive %s: %w", archivePath, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	files = map[string][]byte{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read the archive %s: %w", archivePath, err)
+		}
+		name := path.Clean(header.Name)
+		if header.Typeflag != tar.TypeReg || !slices.Contains(names, name) {
+			continue
+		}
+		files[name], err = io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s from the archive %s: %w", name, archivePath, err)
+		}
+	}
+}
+
+// newDockerArchiveBackend reads the images of a docker save archive without any daemon. An
+// archive holding the base image as well allows the FROM image to be found.
+func newDockerArchiveBackend(archivePath string) (backend *indexBackend, err error) {
+	if archivePath == "-" {
+		return newStdinArchiveBackend()
+	}
+	if !fileExists(archivePath) {
+		return nil, fmt.Errorf("the archive %s does not exist", archivePath)
+	}
+	return &indexBackend{
+		source: fmt.Sprintf("the archive %s", archivePath),
+		load: func(ctx context.Context) (*ociImageIndex, error) {
+			return loadDockerArchive(archivePath)
+		},
+	}, nil
+}
+
+// newStdinArchiveBackend reads a docker save stream piped into dfimage, e.g. over ssh. The archive
+// is read twice, so the stream is spooled to a temporary file that is removed once it is loaded.
+func newStdinArchiveBackend() (backend *indexBackend, err error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return nil, fmt.Errorf("no archive was piped into STDIN - use e.g. docker save myapp:1.0 | dfimage --input -")
+	}
+	return &indexBackend{
+		source: "the archive read from STDIN",
+		load: func(ctx context.Context) (*ociImageIndex, error) {
+			spool, err := os.CreateTemp("", "dfimage-*.tar")
+			if err != nil {
+				return nil, fmt.Errorf("unable to create a temporary file for the archive: %w", err)
+			}
+			defer os.Remove(spool.Name())
+			_, err = io.Copy(spool, os.Stdin)
+			if closeErr := spool.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to read the archive from STDIN: %w", err)
+			}
+			return loadDockerArchive(spool.Name())
+		},
+	}, nil
+}
+
+func loadDockerArchive(archivePath string) (index *ociImageIndex, err error) {
+	files, err := readArchiveFiles(archivePath, []string{"manifest.json"})
+	if err != nil {
+		return nil, err
+	}
+	contents, ok := files["manifest.json"]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a docker save archive - manifest.json is missing", archivePath)
+	}
+	var manifest []dockerArchiveManifest
+	err = json.Unmarshal(contents, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the manifest.json of %s: %w", archivePath, err)
+	}
+
+	// The configs usually come before manifest.json, so they are read in a second pass
+	var configNames []string
+	for _, entry := range manifest {
+		configNames = append(configNames, path.Clean(entry.Config))
+	}
+	configs, err := readArchiveFiles(archivePath, configNames)
+	if err != nil {
+		return nil, err
+	}
+
+	index = newOciImageIndex()
+	for _, entry := range manifest {
+		blob, ok := configs[path.Clean(entry.Config)]
+		if !ok {
+			return nil, fmt.Errorf("the archive %s does not contain the image config %s", archivePath, entry.Config)
+		}
+		var config dockerImage
+		err = json.Unmarshal(blob, &config)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the image config %s: %w", entry.Config, err)
+		}
+		id := digest.FromBytes(blob).String()
+		img := index.add(id, config)
+		for _, source := range entry.LayerSources {
+			if isForeignLayer(source.MediaType) {
+				img.manifest.ForeignLayers++
+			}
+		}
+		for _, tag := range entry.RepoTags {
+			index.addName(id, tag, "")
+		}
+	}
+	index.sortNames()
+	return index, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/opencontainers/go-digest"
+)
+
+// testImageConfig makes an image config with the steps of its history, oldest first.
+func testImageConfig(t *testing.T, created string, steps ...string) (config []byte) {
+	history := []map[string]string{}
+	for _, step := range steps {
+		history = append(history, map[string]string{"created": created, "created_by": step})
+	}
+	config, err := json.Marshal(map[string]any{
+		"created":      created,
+		"architecture": "amd64",
+		"os":           "linux",
+		"config":       map[string]any{"Cmd": []string{"/bin/sh"}},
+		"rootfs":       map[string]any{"type": "layers", "diff_ids": []string{"sha256:" + digest.FromString(created).Hex()}},
+		"history":      history,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// writeTestTar writes the files to a tar archive, gzip compressed if asked to.
+func writeTestTar(t *testing.T, archivePath string, compress bool, files map[string][]byte) {
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if compress {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tw.Write(files[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeTestDockerArchive writes a docker save archive of an app image tagged twice, built on a base
+// image whose Windows layer is left out of the archive.
+func writeTestDockerArchive(t *testing.T, compress bool) (archivePath string, appId string, baseId string) {
+	appConfig := testImageConfig(t, "2024-06-01T00:00:00Z", "ADD alpine-minirootfs-3.20.0-x86_64.tar.gz / # buildkit", `CMD ["/bin/sh"]`, "RUN /bin/sh -c make # buildkit")
+	baseConfig := testImageConfig(t, "2024-05-01T00:00:00Z", "Apply image 10.0.17763.5936")
+	manifest, err := json.Marshal([]map[string]any{
+		{
+			"Config":   "app.json",
+			"RepoTags": []string{"app:1.0", "registry.example.com/app:latest"},
+			"Layers":   []string{"app/layer.tar"},
+		},
+		{
+			"Config":   "./base.json",
+			"RepoTags": []string{"base:ltsc2019"},
+			"Layers":   []string{"base/layer.tar"},
+			"LayerSources": map[string]any{
+				"sha256:" + digest.FromString("base").Hex(): map[string]any{
+					"mediaType": "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip",
+					"size":      1,
+					"digest":    "sha256:" + digest.FromString("base").Hex(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivePath = filepath.Join(t.TempDir(), "images.tar")
+	writeTestTar(t, archivePath, compress, map[string][]byte{
+		"app.json":      appConfig,
+		"base.json":     baseConfig,
+		"manifest.json": manifest,
+	})
+	return archivePath, digest.FromBytes(appConfig).String(), digest.FromBytes(baseConfig).String()
+}
+
+func TestDockerArchiveBackend(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		name := "tar"
+		if compress {
+			name = "tar.gz"
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			archivePath, appId, baseId := writeTestDockerArchive(t, compress)
+			cli, err := newDockerArchiveBackend(archivePath)
+			if err != nil {
+				t.Fatalf("newDockerArchiveBackend() error = %v", err)
+			}
+
+			imageList, err := cli.ImageList(ctx, image.ListOptions{})
+			if err != nil {
+				t.Fatalf("ImageList() error = %v", err)
+			}
+			var ids []string
+			for _, img := range imageList {
+				ids = append(ids, img.ID)
+			}
+			if !slices.Equal(ids, []string{appId, baseId}) {
+				t.Errorf("ImageList() = %v, want the app and then the base image", ids)
+			}
+
+			// Every tag of an entry of manifest.json selects its image
+			for _, tag := range []string{"app:1.0", "registry.example.com/app:latest", "docker.io/library/app:1.0"} {
+				inspect, _, err := cli.ImageInspectWithRaw(ctx, tag)
+				if err != nil {
+					t.Fatalf("ImageInspectWithRaw(%s) error = %v", tag, err)
+				}
+				if inspect.ID != appId {
+					t.Errorf("ImageInspectWithRaw(%s) found %s, want %s", tag, inspect.ID, appId)
+				}
+			}
+			inspect, _, err := cli.ImageInspectWithRaw(ctx, "base:ltsc2019")
+			if err != nil || inspect.ID != baseId {
+				t.Errorf("ImageInspectWithRaw(base:ltsc2019) = %s, %v, want %s", inspect.ID, err, baseId)
+			}
+			_, _, err = cli.ImageInspectWithRaw(ctx, "app:2.0")
+			if err == nil {
+				t.Errorf("ImageInspectWithRaw(app:2.0) found an image that is not in the archive")
+			}
+
+			// The history is newest first, like the docker daemon returns it
+			imageHistory, err := cli.ImageHistory(ctx, "app:1.0")
+			if err != nil {
+				t.Fatalf("ImageHistory() error = %v", err)
+			}
+			var steps []string
+			for _, item := range imageHistory {
+				steps = append(steps, item.CreatedBy)
+			}
+			want := []string{"RUN /bin/sh -c make # buildkit", `CMD ["/bin/sh"]`, "ADD alpine-minirootfs-3.20.0-x86_64.tar.gz / # buildkit"}
+			if !slices.Equal(steps, want) {
+				t.Errorf("ImageHistory() = %q, want %q", steps, want)
+			}
+			if imageHistory[0].ID != appId || imageHistory[1].ID != "<missing>" {
+				t.Errorf("ImageHistory() IDs = %s, %s, want the image ID on the newest step only", imageHistory[0].ID, imageHistory[1].ID)
+			}
+
+			details, err := cli.ManifestDetails(ctx, "base:ltsc2019")
+			if err != nil || details.ForeignLayers != 1 {
+				t.Errorf("ManifestDetails(base:ltsc2019) = %d foreign layers, %v, want 1", details.ForeignLayers, err)
+			}
+		})
+	}
+}
+
+func TestDockerArchiveBackendMissingConfig(t *testing.T) {
+	manifest := []byte(`[{"Config": "app.json", "RepoTags": ["app:1.0"], "Layers": []}]`)
+	archivePath := filepath.Join(t.TempDir(), "images.tar")
+	writeTestTar(t, archivePath, false, map[string][]byte{"manifest.json": manifest})
+	cli, err := newDockerArchiveBackend(archivePath)
+	if err != nil {
+		t.Fatalf("newDockerArchiveBackend() error = %v", err)
+	}
+	_, err = cli.ImageList(context.Background(), image.ListOptions{})
+	if err == nil {
+		t.Errorf("ImageList() read an archive without the image config")
+	}
+}
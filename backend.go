@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+)
+
+// imageBackend is the part of the docker API dfimage reads images through. Other image sources
+// implement it by converting their images into docker's types, so the extraction stays the same
+// whatever the images come from.
+type imageBackend interface {
+	ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error)
+	ImageInspectWithRaw(ctx context.Context, imageId string) (types.ImageInspect, []byte, error)
+	ImageHistory(ctx context.Context, imageId string) ([]image.HistoryResponseItem, error)
+}
+
+// manifestDetails is what an image manifest tells beyond the docker API.
+type manifestDetails struct {
+	// ForeignLayers counts the layers whose content is not distributed with the image, which only the
+	// layer media types tell apart
+	ForeignLayers int
+	// Schema1 marks an image read from a Docker schema 1 manifest, which has no image config
+	Schema1 bool
+	// Annotations are those of the image manifest, which may name the base image
+	Annotations map[string]string
+	// Provenance is the SLSA provenance statement BuildKit attached to the image, if any
+	Provenance []byte
+}
+
+// manifestReader is implemented by the image sources that read image manifests.
+type manifestReader interface {
+	ManifestDetails(ctx context.Context, imageId string) (manifestDetails, error)
+}
+
+// backendSource tells where the images of a backend come from, such as the daemon it talks to, so
+// that what is cached about the images of one source is not pruned for another.
+func backendSource(cli imageBackend) (source string) {
+	switch cli := cli.(type) {
+	case *cachingBackend:
+		return backendSource(cli.imageBackend)
+	case *dockerClient:
+		return "the daemon " + cli.host
+	case *indexBackend:
+		return cli.source
+	}
+	return ""
+}
+
+// newBackend connects to the image source selected with --input, --remote or --runtime. The docker
+// and docker-daemon transports of --input have already been turned into --remote and --image.
+func newBackend(opts *Options, config *Config) (backend imageBackend, err error) {
+	if opts.Input != "" {
+		transport, location, err := parseInput(opts.Input)
+		if err != nil {
+			return nil, err
+		}
+		var cli *indexBackend
+		switch transport {
+		case "docker-archive":
+			cli, err = newDockerArchiveBackend(location)
+		case "oci":
+			cli, err = newOciLayoutBackend(location, requestedPlatform(opts))
+		case "dir":
+			cli, err = newImageDirectoryBackend(location, requestedPlatform(opts))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return cli, nil
+	}
+	if opts.Remote {
+		cli, err := newRemoteBackend(opts, config)
+		if err != nil {
+			return nil, err
+		}
+		return cli, nil
+	}
+	switch opts.Runtime {
+	case "containerd":
+		cli, err := newContainerdBackend(opts)
+		if err != nil {
+			return nil, err
+		}
+		return cli, nil
+	case "cri":
+		cli, err := newCriBackend(opts)
+		if err != nil {
+			return nil, err
+		}
+		return cli, nil
+	}
+	cli, err := newDockerClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newCachingBackend(cli, opts.NoCache), nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// baseline lets existing images adopt a policy without failing right away: accepted findings are
+// dropped and the severity of whole rules can be lowered.
+//
+//	severity:
+//	  non-root: warning      # error, warning or off
+//	findings:
+//	  - rule_id: source-label
+//	    message: the required label org.opencontainers.image.source is missing
+type baseline struct {
+	Severity map[string]string `yaml:"severity,omitempty"`
+	Findings []baselineFinding `yaml:"findings,omitempty"`
+}
+
+// baselineFinding identifies an accepted finding by its rule and message.
+type baselineFinding struct {
+	RuleID  string `yaml:"rule_id"`
+	Message string `yaml:"message"`
+}
+
+func loadBaseline(path string) (b *baseline, err error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the baseline file: %w", err)
+	}
+	b = &baseline{}
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	err = decoder.Decode(b)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unable to parse the baseline file %s: %w", path, err)
+	}
+	for ruleId, severity := range b.Severity {
+		switch severity {
+		case "error", "warning", "off":
+		default:
+			return nil, fmt.Errorf("the baseline file %s sets an unknown severity \"%s\" for %s - use error, warning or off", path, severity, ruleId)
+		}
+	}
+	return b, nil
+}
+
+// apply drops accepted findings and those of rules turned off, and applies the severity overrides.
+// A nil baseline keeps every finding.
+func (b *baseline) apply(findings []finding) (kept []finding, suppressed int) {
+	if b == nil {
+		return findings, 0
+	}
+	for _, f := range findings {
+		if slices.Contains(b.Findings, baselineFinding{RuleID: f.RuleID, Message: f.Message}) {
+			suppressed++
+			continue
+		}
+		switch severity := b.Severity[f.RuleID]; severity {
+		case "off":
+			suppressed++
+			continue
+		case "error", "warning":
+			f.Severity = severity
+		}
+		kept = append(kept, f)
+	}
+	return kept, suppressed
+}
+
+// writeBaseline accepts every current finding, keeping the severity overrides of the baseline
+// in use, if any.
+func writeBaseline(path string, current *baseline, findings []finding) (b *baseline, err error) {
+	b = &baseline{}
+	if current != nil {
+		b.Severity = current.Severity
+	}
+	for _, f := range findings {
+		b.Findings = append(b.Findings, baselineFinding{RuleID: f.RuleID, Message: f.Message})
+	}
+	slices.SortFunc(b.Findings, func(x, y baselineFinding) int {
+		return cmp.Or(cmp.Compare(x.RuleID, y.RuleID), cmp.Compare(x.Message, y.Message))
+	})
+	b.Findings = slices.Compact(b.Findings)
+
+	var buf bytes.Buffer
+	buf.WriteString("# Findings accepted by dfimage --write-baseline. Remove an entry to enforce it again.\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode the baseline: %w", err)
+	}
+	err = os.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write the baseline file: %w", err)
+	}
+	return b, nil
+}
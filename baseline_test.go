@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBaselineApply(t *testing.T) {
+	b := &baseline{
+		Severity: map[string]string{"non-root": "warning", "experimental": "off"},
+		Findings: []baselineFinding{
+			{RuleID: "source-label", Message: "the required label org.opencontainers.image.source is missing"},
+		},
+	}
+	tests := []struct {
+		name       string
+		baseline   *baseline
+		finding    finding
+		kept       bool
+		severity   string
+		suppressed int
+	}{
+		{
+			name:       "an accepted finding",
+			baseline:   b,
+			finding:    finding{RuleID: "source-label", Severity: "error", Message: "the required label org.opencontainers.image.source is missing"},
+			suppressed: 1,
+		},
+		{
+			name:     "a new finding of a rule with accepted findings",
+			baseline: b,
+			finding:  finding{RuleID: "source-label", Severity: "error", Message: "the required label org.opencontainers.image.version is missing"},
+			kept:     true,
+			severity: "error",
+		},
+		{
+			name:     "a finding of a rule with a lower severity",
+			baseline: b,
+			finding:  finding{RuleID: "non-root", Severity: "error", Message: "the image runs as root because no USER is set"},
+			kept:     true,
+			severity: "warning",
+		},
+		{
+			name:       "a finding of a rule turned off",
+			baseline:   b,
+			finding:    finding{RuleID: "experimental", Severity: "error", Message: "instruction 3 matches the denied pattern ^ADD"},
+			suppressed: 1,
+		},
+		{
+			name:     "no baseline",
+			finding:  finding{RuleID: "source-label", Severity: "error", Message: "the required label org.opencontainers.image.source is missing"},
+			kept:     true,
+			severity: "error",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kept, suppressed := test.baseline.apply([]finding{test.finding})
+			if suppressed != test.suppressed {
+				t.Errorf("apply() suppressed %d findings, want %d", suppressed, test.suppressed)
+			}
+			if (len(kept) == 1) != test.kept {
+				t.Fatalf("apply() kept %v, want the finding kept: %t", kept, test.kept)
+			}
+			if test.kept && kept[0].Severity != test.severity {
+				t.Errorf("apply() severity = %s, want %s", kept[0].Severity, test.severity)
+			}
+		})
+	}
+}
+
+func TestWriteBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.yaml")
+	findings := []finding{
+		{RuleID: "source-label", Severity: "error", Message: "the required label org.opencontainers.image.source is missing"},
+		{RuleID: "non-root", Severity: "error", Message: "the image runs as root because no USER is set"},
+		{RuleID: "non-root", Severity: "error", Message: "the image runs as root because no USER is set"},
+	}
+	_, err := writeBaseline(path, &baseline{Severity: map[string]string{"non-root": "warning"}}, findings)
+	if err != nil {
+		t.Fatalf("writeBaseline() error = %v", err)
+	}
+	b, err := loadBaseline(path)
+	if err != nil {
+		t.Fatalf("loadBaseline() error = %v", err)
+	}
+	want := []baselineFinding{
+		{RuleID: "non-root", Message: "the image runs as root because no USER is set"},
+		{RuleID: "source-label", Message: "the required label org.opencontainers.image.source is missing"},
+	}
+	if !slices.Equal(b.Findings, want) {
+		t.Errorf("the baseline accepts %v, want %v", b.Findings, want)
+	}
+	if b.Severity["non-root"] != "warning" {
+		t.Errorf("the baseline lost the severity override of non-root")
+	}
+
+	// A later run only keeps what the baseline did not accept
+	kept, suppressed := b.apply(append(findings, finding{RuleID: "source-label", Severity: "error", Message: "the required label org.opencontainers.image.version is missing"}))
+	if suppressed != 3 || len(kept) != 1 || kept[0].Message != "the required label org.opencontainers.image.version is missing" {
+		t.Errorf("apply() kept %v and suppressed %d findings, want only the new finding kept", kept, suppressed)
+	}
+}
+
+func TestLoadBaselineSeverity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.yaml")
+	err := os.WriteFile(path, []byte("severity:\n  non-root: fatal\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadBaseline(path)
+	if err == nil {
+		t.Errorf("loadBaseline() accepted an unknown severity")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+// The builders a step of the image history can come from.
+const (
+	BUILDER_BUILDKIT = "buildkit"
+	BUILDER_CLASSIC  = "classic"
+	BUILDER_BUILDAH  = "buildah"
+	BUILDER_COMMIT   = "commit"
+)
+
+// BUILDER_NAMES are the names of the builders in comments and markdown.
+var BUILDER_NAMES = map[string]string{
+	BUILDER_BUILDKIT: "BuildKit",
+	BUILDER_CLASSIC:  "the classic builder",
+	BUILDER_BUILDAH:  "Buildah",
+	BUILDER_COMMIT:   "docker commit",
+}
+
+// Buildah sets this label on the images it builds
+const LABEL_BUILDAH_VERSION = "io.buildah.version"
+
+// A RUN step of the classic builder and Buildah is its shell command line, prefixed with the build
+// arguments it used, e.g. |1 VERSION=1.2 /bin/sh -c make
+var shellStep = regexp.MustCompile(`^(\|\d+ .*?)?(/bin/sh -c|/bin/bash -c|cmd /S /C|powershell -Command) `)
+
+// BUILDKIT_MARKER ends the created_by of the BuildKit steps that change the filesystem
+const BUILDKIT_MARKER = "# buildkit"
+
+// A BuildKit step is recorded as its instruction, e.g. ENV APP_HOME=/app or
+// RUN |1 VERSION=1.2 /bin/sh -c make # buildkit
+var buildkitStep = regexp.MustCompile(`^(ADD|ARG|CMD|COPY|ENTRYPOINT|ENV|EXPOSE|HEALTHCHECK|LABEL|MAINTAINER|ONBUILD|RUN|SHELL|STOPSIGNAL|USER|VOLUME|WORKDIR) `)
+
+// isBuildahImage tells images built by Buildah or Podman, which write their steps the way the
+// classic builder does, by their label or by the FROM comment Buildah leaves on the first step.
+func isBuildahImage(labels map[string]string, imageHistory []image.HistoryResponseItem) bool {
+	if labels[LABEL_BUILDAH_VERSION] != "" {
+		return true
+	}
+	for _, imageEvent := range imageHistory {
+		if strings.HasPrefix(imageEvent.Comment, "FROM ") {
+			return true
+		}
+	}
+	return false
+}
+
+// historyBuilder tells which builder produced a step of the image history. BuildKit writes its steps
+// as instructions, the classic builder and Buildah record a #(nop) marker or a shell command line,
+// and anything else is the command of a container that was committed.
+func historyBuilder(imageEvent image.HistoryResponseItem, buildah bool) (builder string) {
+	createdBy := strings.TrimSpace(imageEvent.CreatedBy)
+	switch {
+	case imageEvent.Comment == "buildkit.dockerfile.v0" || buildkitStep.MatchString(createdBy):
+		return BUILDER_BUILDKIT
+	case !strings.Contains(createdBy, "#(nop)") && !shellStep.MatchString(createdBy):
+		return BUILDER_COMMIT
+	case buildah:
+		return BUILDER_BUILDAH
+	}
+	return BUILDER_CLASSIC
+}
+
+// builderSummary lists the builders of an image in the order they first appear, e.g.
+// "the classic builder, BuildKit" for a BuildKit image on a classic base.
+func builderSummary(builders []string) (summary string) {
+	var names []string
+	for _, builder := range builders {
+		if builder != "" && !slices.Contains(names, BUILDER_NAMES[builder]) {
+			names = append(names, BUILDER_NAMES[builder])
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// builderComments returns the comment to write above every instruction when --annotate-builders is
+// set, which names the builder wherever it changes. Instructions without a comment get "".
+func builderComments(document jsonDocument) (comments []string) {
+	comments = make([]string, len(document.Instructions))
+	if !document.annotateBuilders || len(document.Builders) != len(document.Instructions) {
+		return comments
+	}
+	var previous string
+	for i, builder := range document.Builders {
+		if builder != "" && builder != previous {
+			comments[i] = fmt.Sprintf("# Built by %s", BUILDER_NAMES[builder])
+		}
+		if builder != "" {
+			previous = builder
+		}
+	}
+	return comments
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"time"
+)
+
+type bundleFile struct {
+	Name     string
+	Contents []byte
+}
+
+// writeBundle writes the Dockerfile and its metadata to a gzipped tarball at path. Without a
+// generation time (deterministic mode) every archive timestamp is pinned to the Unix epoch.
+func writeBundle(path string, document jsonDocument, config *Config) (err error) {
+	modTime := time.Unix(0, 0)
+	if document.GeneratedAt != nil {
+		modTime = *document.GeneratedAt
+	}
+	dockerfile, err := renderDockerfile(document, config)
+	if err != nil {
+		return err
+	}
+	metadataJson, err := renderJson(document)
+	if err != nil {
+		return err
+	}
+
+	files := []bundleFile{
+		{Name: "Dockerfile", Contents: []byte(dockerfile)},
+		{Name: "metadata.json", Contents: []byte(metadataJson)},
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		err = tw.WriteHeader(&tar.Header{
+			Name:    file.Name,
+			Mode:    0644,
+			Size:    int64(len(file.Contents)),
+			ModTime: modTime,
+			Format:  tar.FormatPAX,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write the bundle: %w", err)
+		}
+		_, err = tw.Write(file.Contents)
+		if err != nil {
+			return fmt.Errorf("unable to write the bundle: %w", err)
+		}
+	}
+	err = tw.Close()
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write the bundle: %w", err)
+	}
+
+	err = os.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write the bundle %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/go-units"
+)
+
+// RESULT_CACHE_VERSION is part of every cache key, so a result is only served by the build of
+// dfimage that rendered it.
+var RESULT_CACHE_VERSION = sync.OnceValue(buildRevision)
+
+// The kinds of cache entries
+const (
+	CACHE_RESULT     = "result"
+	CACHE_INSPECTION = "inspection"
+	CACHE_RUN        = "run"
+)
+
+type cachedResult struct {
+	Created time.Time `json:"created"`
+	// Source is the backend the image was read from, as backendSource tells it
+	Source     string     `json:"source,omitempty"`
+	Extraction extraction `json:"extraction"`
+}
+
+type cacheEntry struct {
+	Path   string
+	Size   int64
+	Result cachedResult
+	// Kind is a rendered result, the inspect and history of an image, or the record of a batch run
+	Kind string
+}
+
+type cacheCommand struct {
+	List  cacheListCommand  `command:"ls" description:"List the cached results."`
+	Prune cachePruneCommand `command:"prune" description:"Remove cached results, inspections and run records for images of the daemon that it no longer has, or that are older than --older-than."`
+	Clear cacheClearCommand `command:"clear" description:"Remove every cached result, inspection and run record."`
+}
+
+type cacheListCommand struct{}
+
+type cachePruneCommand struct {
+	OlderThan string `long:"older-than" description:"Remove entries cached longer ago than this age (e.g. 12h, 30d, 2w) without contacting the daemon."`
+	opts      *Options
+}
+
+type cacheClearCommand struct{}
+
+func resultCacheDir() (dir string, err error) {
+	return cacheSubdir("results")
+}
+
+func cacheSubdir(name string) (dir string, err error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to locate the cache directory: %w", err)
+	}
+	dir = filepath.Join(cacheDir, "dfimage", name)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("unable to create the cache directory %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// buildRevision identifies the build of dfimage: the VCS revision it was built from, the module
+// version go install recorded, or the size and time of the executable for a build of a modified
+// tree, which has neither.
+func buildRevision() (revision string) {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		settings := map[string]string{}
+		for _, setting := range info.Settings {
+			settings[setting.Key] = setting.Value
+		}
+		if settings["vcs.revision"] != "" && settings["vcs.modified"] != "true" {
+			return settings["vcs.revision"]
+		}
+		if info.Main.Version != "" && info.Main.Version != "(devel)" && !strings.HasSuffix(info.Main.Version, "+dirty") {
+			return info.Main.Version
+		}
+	}
+	executable, err := os.Executable()
+	if err == nil {
+		if stat, err := os.Stat(executable); err == nil {
+			return fmt.Sprintf("%d-%d", stat.Size(), stat.ModTime().UnixNano())
+		}
+	}
+	return VERSION
+}
+
+// resultCacheKey identifies a rendered result. The FROM detection depends on which other images
+// are present on the daemon, so the IDs of all local images are hashed in with the backend, the
+// options and the parts of the config file that change where base images are looked up.
+func resultCacheKey(imageId string, imageList []image.Summary, opts *Options, config *Config) (key string) {
+	var imageIds []string
+	for _, img := range imageList {
+		imageIds = append(imageIds, img.ID)
+	}
+	slices.Sort(imageIds)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "version=%s\n", RESULT_CACHE_VERSION())
+	fmt.Fprintf(h, "backend=%s\n", selectedBackend(opts))
+	fmt.Fprintf(h, "image=%s\n", imageId)
+	fmt.Fprintf(h, "deterministic=%t\n", opts.Deterministic)
+	fmt.Fprintf(h, "group-labels=%t\n", opts.GroupLabels)
+	fmt.Fprintf(h, "collapse-workdirs=%t\n", opts.CollapseWorkdirs)
+	fmt.Fprintf(h, "pin-digests=%t\n", opts.PinDigests)
+	fmt.Fprintf(h, "lookup-bases=%t\n", opts.LookupBases)
+	fmt.Fprintf(h, "images=%s\n", strings.Join(imageIds, ","))
+	var mirrors []string
+	for registry, mirror := range config.Mirrors {
+		mirrors = append(mirrors, registry+"="+mirror)
+	}
+	slices.Sort(mirrors)
+	fmt.Fprintf(h, "mirrors=%s\n", strings.Join(mirrors, ","))
+	fmt.Fprintf(h, "base-candidates=%s\n", strings.Join(config.BaseCandidates, ","))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func readCachedResult(key string) (result cachedResult, ok bool) {
+	dir, err := resultCacheDir()
+	if err != nil {
+		return result, false
+	}
+	contents, err := os.ReadFile(filepath.Join(dir, key+".json"))
+	if err != nil {
+		return result, false
+	}
+	// Entries written by older versions have no extraction and are simply regenerated
+	if json.Unmarshal(contents, &result) != nil || result.Extraction.ImageID == "" {
+		return result, false
+	}
+	return result, true
+}
+
+func writeCachedResult(key string, result cachedResult) (err error) {
+	dir, err := resultCacheDir()
+	if err != nil {
+		return err
+	}
+	contents, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(filepath.Join(dir, key+".json"), contents)
+}
+
+func writeCacheFile(path string, contents []byte) (err error) {
+	// Write to a temporary file first so a concurrent reader never sees a partial entry
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func readCacheEntries() (entries []cacheEntry, err error) {
+	dir, err := resultCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		var result cachedResult
+		info, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		// Unreadable entries are still listed so they can be pruned
+		if json.Unmarshal(contents, &result) != nil || result.Created.IsZero() {
+			result.Created = info.ModTime()
+		}
+		entries = append(entries, cacheEntry{Path: path, Size: info.Size(), Result: result, Kind: CACHE_RESULT})
+	}
+	return entries, nil
+}
+
+// parseAge parses a duration that may also use d (days) and w (weeks) units, e.g. 30d or 2w.
+func parseAge(age string) (duration time.Duration, err error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if count, ok := strings.CutSuffix(age, suffix); ok {
+			n, err := strconv.ParseFloat(count, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid age %q", age)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	duration, err = time.ParseDuration(age)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid age %q", age)
+	}
+	return duration, nil
+}
+
+// readAllCacheEntries reads the rendered results, the inspections and the records of batch runs.
+func readAllCacheEntries() (entries []cacheEntry, err error) {
+	for _, read := range []func() ([]cacheEntry, error){readCacheEntries, readInspectCacheEntries, readRunRecordEntries} {
+		kindEntries, err := read()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, kindEntries...)
+	}
+	return entries, nil
+}
+
+func removeCacheEntries(entries []cacheEntry) (err error) {
+	var freed int64
+	removed := map[string]int{}
+	for _, entry := range entries {
+		err = os.Remove(entry.Path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		freed += entry.Size
+		removed[entry.Kind]++
+	}
+	fmt.Printf("Removed %d cached results, %d cached inspections and %d run records, freeing %s.\n", removed[CACHE_RESULT], removed[CACHE_INSPECTION], removed[CACHE_RUN], units.HumanSize(float64(freed)))
+	return nil
+}
+
+func shortImageId(imageId string) string {
+	imageId = strings.TrimPrefix(imageId, "sha256:")
+	if len(imageId) > 12 {
+		return imageId[:12]
+	}
+	return imageId
+}
+
+func (c *cacheListCommand) Execute(args []string) (err error) {
+	entries, err := readCacheEntries()
+	if err != nil {
+		return err
+	}
+	var total int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "IMAGE\tIMAGE ID\tCACHED\tSIZE")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Result.Extraction.Image, shortImageId(entry.Result.Extraction.ImageID), entry.Result.Created.Local().Format("2006-01-02 15:04:05"), units.HumanSize(float64(entry.Size)))
+		total += entry.Size
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n%d cached results, %s total\n", len(entries), units.HumanSize(float64(total)))
+
+	inspections, err := readInspectCacheEntries()
+	if err != nil {
+		return err
+	}
+	total = 0
+	for _, entry := range inspections {
+		total += entry.Size
+	}
+	fmt.Printf("%d cached inspections, %s total\n", len(inspections), units.HumanSize(float64(total)))
+
+	runs, err := readRunRecordEntries()
+	if err != nil {
+		return err
+	}
+	total = 0
+	for _, entry := range runs {
+		total += entry.Size
+	}
+	fmt.Printf("%d run records, %s total\n", len(runs), units.HumanSize(float64(total)))
+	return nil
+}
+
+func (c *cachePruneCommand) Execute(args []string) (err error) {
+	if c.OlderThan != "" {
+		return c.pruneOlderThan()
+	}
+
+	ctx, cancel := newContext(c.opts)
+	defer cancel()
+
+	config, err := loadConfig(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+	cli, err := newBackend(c.opts, config)
+	if err != nil {
+		return err
+	}
+	imageList, err := cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to generate the list of images: %w", err)
+	}
+	var imageIds []string
+	for _, img := range imageList {
+		imageIds = append(imageIds, img.ID)
+	}
+
+	entries, err := readAllCacheEntries()
+	if err != nil {
+		return err
+	}
+	return removeCacheEntries(staleCacheEntries(entries, backendSource(cli), imageIds))
+}
+
+// staleCacheEntries picks the entries of images the source no longer has. The entries of other
+// sources, and those written before entries recorded their source, are left to --older-than, and
+// unreadable entries are always stale.
+func staleCacheEntries(entries []cacheEntry, source string, imageIds []string) (stale []cacheEntry) {
+	for _, entry := range entries {
+		imageId := entry.Result.Extraction.ImageID
+		if imageId == "" || (entry.Result.Source == source && !slices.Contains(imageIds, imageId)) {
+			stale = append(stale, entry)
+		}
+	}
+	return stale
+}
+
+func (c *cachePruneCommand) pruneOlderThan() (err error) {
+	age, err := parseAge(c.OlderThan)
+	if err != nil {
+		return fmt.Errorf("--older-than: %w", err)
+	}
+	entries, err := readAllCacheEntries()
+	if err != nil {
+		return err
+	}
+	var stale []cacheEntry
+	cutoff := time.Now().Add(-age)
+	for _, entry := range entries {
+		if entry.Result.Created.Before(cutoff) {
+			stale = append(stale, entry)
+		}
+	}
+	return removeCacheEntries(stale)
+}
+
+func (c *cacheClearCommand) Execute(args []string) (err error) {
+	entries, err := readAllCacheEntries()
+	if err != nil {
+		return err
+	}
+	return removeCacheEntries(entries)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+func TestStaleCacheEntries(t *testing.T) {
+	entry := func(path string, source string, imageId string) cacheEntry {
+		entry := cacheEntry{Path: path, Kind: CACHE_RESULT}
+		entry.Result.Source = source
+		entry.Result.Extraction.ImageID = imageId
+		return entry
+	}
+	const source = "the daemon unix:///var/run/docker.sock"
+	tests := []struct {
+		name     string
+		entry    cacheEntry
+		imageIds []string
+		stale    bool
+	}{
+		{
+			name:     "an image the source still has",
+			entry:    entry("a.json", source, "sha256:aaa"),
+			imageIds: []string{"sha256:aaa"},
+		},
+		{
+			name:     "an image the source no longer has",
+			entry:    entry("a.json", source, "sha256:aaa"),
+			imageIds: []string{"sha256:bbb"},
+			stale:    true,
+		},
+		{
+			name:     "an image of another daemon",
+			entry:    entry("a.json", "the daemon ssh://build-host", "sha256:aaa"),
+			imageIds: []string{"sha256:bbb"},
+		},
+		{
+			name:     "an entry that records no source",
+			entry:    entry("a.json", "", "sha256:aaa"),
+			imageIds: []string{"sha256:bbb"},
+		},
+		{
+			name:     "an unreadable entry",
+			entry:    entry("a.json", "", ""),
+			imageIds: []string{"sha256:aaa"},
+			stale:    true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stale := staleCacheEntries([]cacheEntry{test.entry}, source, test.imageIds)
+			if got := len(stale) == 1; got != test.stale {
+				t.Errorf("staleCacheEntries() picked the entry: %t, want %t", got, test.stale)
+			}
+		})
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	imageList := []image.Summary{{ID: "sha256:aaa"}, {ID: "sha256:bbb"}}
+	base := resultCacheKey("sha256:aaa", imageList, &Options{}, &Config{})
+	tests := []struct {
+		name      string
+		imageId   string
+		imageList []image.Summary
+		opts      Options
+		config    Config
+		changed   bool
+	}{
+		{
+			name: "the same inputs",
+		},
+		{
+			name:      "the local images in another order",
+			imageList: []image.Summary{{ID: "sha256:bbb"}, {ID: "sha256:aaa"}},
+		},
+		{
+			name:    "another image",
+			imageId: "sha256:bbb",
+			changed: true,
+		},
+		{
+			name:      "another set of local images",
+			imageList: []image.Summary{{ID: "sha256:aaa"}, {ID: "sha256:ccc"}},
+			changed:   true,
+		},
+		{
+			name:    "another backend",
+			opts:    Options{Remote: true},
+			changed: true,
+		},
+		{
+			name:    "--deterministic",
+			opts:    Options{Deterministic: true},
+			changed: true,
+		},
+		{
+			name:    "--group-labels",
+			opts:    Options{GroupLabels: true},
+			changed: true,
+		},
+		{
+			name:    "--collapse-workdirs",
+			opts:    Options{CollapseWorkdirs: true},
+			changed: true,
+		},
+		{
+			name:    "--pin-digests",
+			opts:    Options{PinDigests: true},
+			changed: true,
+		},
+		{
+			name:    "--lookup-bases",
+			opts:    Options{LookupBases: true},
+			changed: true,
+		},
+		{
+			name:    "mirrors",
+			config:  Config{Mirrors: map[string]string{"docker.io": "mirror.example.com/dockerhub"}},
+			changed: true,
+		},
+		{
+			name:    "base candidates",
+			config:  Config{BaseCandidates: []string{"registry.example.com/base:1.0"}},
+			changed: true,
+		},
+		{
+			name: "an option that does not change the result",
+			opts: Options{Concurrency: 4},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			imageId := test.imageId
+			if imageId == "" {
+				imageId = "sha256:aaa"
+			}
+			if test.imageList == nil {
+				test.imageList = imageList
+			}
+			key := resultCacheKey(imageId, test.imageList, &test.opts, &test.config)
+			if changed := key != base; changed != test.changed {
+				t.Errorf("resultCacheKey() changed: %t, want %t", changed, test.changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"text/tabwriter"
+)
+
+// The image sources, or backends, dfimage reads images from
+const (
+	BACKEND_DAEMON         = "daemon"
+	BACKEND_DOCKER_ARCHIVE = "docker-archive"
+	BACKEND_OCI            = "oci"
+	BACKEND_DIR            = "dir"
+	BACKEND_REGISTRY       = "registry"
+	BACKEND_CONTAINERD     = "containerd"
+	BACKEND_CRI            = "cri"
+)
+
+// The features a backend may lack
+const (
+	// FEATURE_LIST selects images with --all, --match and --match-re, and is what sync, metrics and
+	// similar go through
+	FEATURE_LIST = "listing images"
+	// FEATURE_REPO_DIGESTS finds the earlier versions of a tag and the digest to pin a base image to
+	FEATURE_REPO_DIGESTS = "repository digests"
+	// FEATURE_PLATFORMS picks the --platform of a multi-platform image
+	FEATURE_PLATFORMS = "platform selection"
+)
+
+var BACKENDS = []string{BACKEND_DAEMON, BACKEND_DOCKER_ARCHIVE, BACKEND_OCI, BACKEND_DIR, BACKEND_REGISTRY, BACKEND_CONTAINERD, BACKEND_CRI}
+
+var FEATURES = []string{FEATURE_LIST, FEATURE_REPO_DIGESTS, FEATURE_PLATFORMS}
+
+// BACKEND_CAPABILITIES lists the features every backend supports. The registry backend only
+// fetches the images it is given, and a docker save archive or an image directory records no
+// repository digests.
+var BACKEND_CAPABILITIES = map[string][]string{
+	BACKEND_DAEMON:         {FEATURE_LIST, FEATURE_REPO_DIGESTS},
+	BACKEND_DOCKER_ARCHIVE: {FEATURE_LIST},
+	BACKEND_OCI:            {FEATURE_LIST, FEATURE_REPO_DIGESTS, FEATURE_PLATFORMS},
+	BACKEND_DIR:            {FEATURE_LIST, FEATURE_PLATFORMS},
+	BACKEND_REGISTRY:       {FEATURE_REPO_DIGESTS, FEATURE_PLATFORMS},
+	BACKEND_CONTAINERD:     {FEATURE_LIST, FEATURE_REPO_DIGESTS, FEATURE_PLATFORMS},
+	BACKEND_CRI:            {FEATURE_LIST, FEATURE_REPO_DIGESTS},
+}
+
+// selectedBackend tells the backend the options select, the way newBackend picks it, without
+// connecting to it.
+func selectedBackend(opts *Options) (backend string) {
+	if opts.Input != "" {
+		transport, _, err := parseInput(opts.Input)
+		switch {
+		case err != nil:
+			return ""
+		case transport == "docker":
+			return BACKEND_REGISTRY
+		case transport == "docker-daemon":
+			return BACKEND_DAEMON
+		}
+		return transport
+	}
+	if opts.Remote {
+		return BACKEND_REGISTRY
+	}
+	switch opts.Runtime {
+	case "containerd":
+		return BACKEND_CONTAINERD
+	case "cri":
+		return BACKEND_CRI
+	}
+	return BACKEND_DAEMON
+}
+
+// requireFeature fails early when the selected backend does not support a feature, rather than
+// leaving the run to fail or go wrong halfway. The hint says what to do instead.
+func requireFeature(opts *Options, feature string, hint string) (err error) {
+	backend := selectedBackend(opts)
+	if backend == "" || slices.Contains(BACKEND_CAPABILITIES[backend], feature) {
+		return nil
+	}
+	return fmt.Errorf("the %s backend does not support %s - %s", backend, feature, hint)
+}
+
+type backendsCommand struct{}
+
+// printCapabilities writes the features of every backend as a table.
+func printCapabilities(w io.Writer) (err error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprint(tw, "BACKEND")
+	for _, feature := range FEATURES {
+		fmt.Fprintf(tw, "\t%s", feature)
+	}
+	fmt.Fprintln(tw)
+	for _, backend := range BACKENDS {
+		fmt.Fprint(tw, backend)
+		for _, feature := range FEATURES {
+			supported := "no"
+			if slices.Contains(BACKEND_CAPABILITIES[backend], feature) {
+				supported = "yes"
+			}
+			fmt.Fprintf(tw, "\t%s", supported)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func (c *backendsCommand) Execute(args []string) (err error) {
+	return printCapabilities(os.Stdout)
+}
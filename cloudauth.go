@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// cloudRegistry describes how to get a token for the registries of one cloud provider when the
+// docker config has no credentials for them: first through the provider's credential helper, then
+// through its CLI, which uses the SDK default credentials of the machine.
+type cloudRegistry struct {
+	name    string
+	hosts   *regexp.Regexp
+	helpers []string
+	// tokenCommand returns the username to send the token with and the CLI command printing it
+	tokenCommand func(match []string) (username string, command []string)
+}
+
+var CLOUD_REGISTRIES = []cloudRegistry{
+	{
+		name:    "Amazon ECR",
+		hosts:   regexp.MustCompile(`^\d+\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`),
+		helpers: []string{"ecr-login"},
+		tokenCommand: func(match []string) (username string, command []string) {
+			return "AWS", []string{"aws", "ecr", "get-login-password", "--region", match[1]}
+		},
+	},
+	{
+		name:    "Google Artifact Registry",
+		hosts:   regexp.MustCompile(`^(?:[a-z0-9-]+\.)?gcr\.io$|^[a-z0-9-]+-docker\.pkg\.dev$`),
+		helpers: []string{"gcr", "gcloud"},
+		tokenCommand: func(match []string) (username string, command []string) {
+			return "oauth2accesstoken", []string{"gcloud", "auth", "print-access-token"}
+		},
+	},
+	{
+		name:    "Azure Container Registry",
+		hosts:   regexp.MustCompile(`^([a-z0-9]+)\.azurecr\.(?:io|cn|us)$`),
+		helpers: []string{"acr-env"},
+		tokenCommand: func(match []string) (username string, command []string) {
+			return "00000000-0000-0000-0000-000000000000", []string{"az", "acr", "login", "--name", match[1], "--expose-token", "--output", "tsv", "--query", "accessToken"}
+		},
+	},
+}
+
+// cloudKeychain authenticates to ECR, GCR, Artifact Registry and ACR. Registries it does not know,
+// or for which neither a helper nor the CLI is installed, are accessed anonymously.
+type cloudKeychain struct{}
+
+func (cloudKeychain) Resolve(resource authn.Resource) (authenticator authn.Authenticator, err error) {
+	host := resource.RegistryStr()
+	for _, registry := range CLOUD_REGISTRIES {
+		match := registry.hosts.FindStringSubmatch(host)
+		if match == nil {
+			continue
+		}
+		for _, helper := range registry.helpers {
+			program := "docker-credential-" + helper
+			if _, err := exec.LookPath(program); err != nil {
+				continue
+			}
+			creds, err := client.Get(client.NewShellProgramFunc(program), host)
+			if err != nil {
+				return nil, fmt.Errorf("the credential helper %s failed for %s: %w", program, host, err)
+			}
+			// Helpers return identity tokens with this placeholder username
+			if creds.Username == "<token>" {
+				return authn.FromConfig(authn.AuthConfig{IdentityToken: creds.Secret}), nil
+			}
+			return authn.FromConfig(authn.AuthConfig{Username: creds.Username, Password: creds.Secret}), nil
+		}
+
+		username, command := registry.tokenCommand(match)
+		if _, err := exec.LookPath(command[0]); err != nil {
+			return authn.Anonymous, nil
+		}
+		token, err := exec.Command(command[0], command[1:]...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get a %s token with \"%s\": %w", registry.name, strings.Join(command, " "), err)
+		}
+		return authn.FromConfig(authn.AuthConfig{Username: username, Password: strings.TrimSpace(string(token))}), nil
+	}
+	return authn.Anonymous, nil
+}
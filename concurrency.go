@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"slices"
+	"sync"
+)
+
+// imageOutput is where the results of one image go: the file given with --outfile or chosen by
+// --output-dir, if any, and the streams its output and messages are written to.
+type imageOutput struct {
+	file   string
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// bufferedOutput keeps what one image writes to STDOUT and STDERR, in the order it was written, so
+// images inspected at the same time can be printed one after another.
+type bufferedOutput struct {
+	chunks []outputChunk
+}
+
+type outputChunk struct {
+	stderr bool
+	data   []byte
+}
+
+type chunkWriter struct {
+	buffer *bufferedOutput
+	stderr bool
+}
+
+func (w chunkWriter) Write(p []byte) (n int, err error) {
+	w.buffer.chunks = append(w.buffer.chunks, outputChunk{stderr: w.stderr, data: slices.Clone(p)})
+	return len(p), nil
+}
+
+func (b *bufferedOutput) output(file string) (out imageOutput) {
+	return imageOutput{file: file, stdout: chunkWriter{buffer: b}, stderr: chunkWriter{buffer: b, stderr: true}}
+}
+
+// replay writes the buffered output to STDOUT and STDERR.
+func (b *bufferedOutput) replay() {
+	PROGRESS.pause(func() {
+		for _, chunk := range b.chunks {
+			if chunk.stderr {
+				os.Stderr.Write(chunk.data)
+			} else {
+				os.Stdout.Write(chunk.data)
+			}
+		}
+	})
+}
+
+// inspectResult is the outcome of inspecting the image of one target of a batch.
+type inspectResult struct {
+	summary imageSummary
+	err     error
+	output  *bufferedOutput
+}
+
+// inspectConcurrently runs inspect for every target on up to concurrency workers and hands the
+// results to report. When ordered, they are reported in the order of the targets, as soon as the
+// ones before are reported, and otherwise as soon as they are done, so a slow image holds up no
+// other. Targets are started in order too, so the output keeps flowing on a large batch. Once ctx
+// is cancelled no more targets are started and the remaining ones are reported with its error.
+func inspectConcurrently(ctx context.Context, concurrency int, count int, ordered bool, inspect func(i int, out *bufferedOutput) (imageSummary, error), report func(i int, result inspectResult)) {
+	results := make([]chan inspectResult, count)
+	for i := range results {
+		results[i] = make(chan inspectResult, 1)
+	}
+	// finished receives the targets in the order their results are ready
+	finished := make(chan int, count)
+	next := make(chan int)
+	go func() {
+		defer close(next)
+		for i := range count {
+			select {
+			case next <- i:
+			case <-ctx.Done():
+				for ; i < count; i++ {
+					results[i] <- inspectResult{err: ctx.Err(), output: &bufferedOutput{}}
+					finished <- i
+				}
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				out := &bufferedOutput{}
+				summary, err := inspect(i, out)
+				results[i] <- inspectResult{summary: summary, err: err, output: out}
+				finished <- i
+			}
+		}()
+	}
+	for i := range count {
+		if !ordered {
+			i = <-finished
+		}
+		report(i, <-results[i])
+	}
+	wg.Wait()
+}
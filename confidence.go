@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+)
+
+// How the base image was found
+const (
+	BASE_SOURCE_LAYERS  = "layers"
+	BASE_SOURCE_HISTORY = "history"
+	BASE_SOURCE_SCRATCH = "scratch"
+	// The image names its base in the OCI base image annotations or labels
+	BASE_SOURCE_ANNOTATION = "annotation"
+	// BuildKit recorded the base image in the provenance it attached to the image
+	BASE_SOURCE_PROVENANCE = "provenance"
+	// The oldest steps of the image match one of BASE_FINGERPRINTS
+	BASE_SOURCE_FINGERPRINT = "fingerprint"
+	// --lookup-bases found a candidate in a registry whose layers start the image
+	BASE_SOURCE_LOOKUP = "lookup"
+)
+
+// SCRATCH is the empty base image of the images that bring their own root filesystem
+const SCRATCH = "scratch"
+
+// baseEvidence is what the FROM line of a reconstruction rests on.
+type baseEvidence struct {
+	// Source is layers when a local image's top layer was found in the image, lookup when
+	// --lookup-bases found the base image in a registry, annotation or provenance when the image
+	// names its base, history when the build recorded the base image, fingerprint when its oldest
+	// steps are those of a common base image, scratch when the image has no parent and its first
+	// step adds its root filesystem, and empty when no base image was found
+	Source string `json:"source,omitempty"`
+	// MatchedLayers is how many layers of the base image start the image, out of BaseLayers
+	MatchedLayers int `json:"matched_layers"`
+	BaseLayers    int `json:"base_layers"`
+	// BaseDigest is the repository digest the FROM line can be pinned to
+	BaseDigest string `json:"base_digest,omitempty"`
+	// RegistryConfirmed is set when --lookup-bases found the base image in a registry
+	RegistryConfirmed bool `json:"registry_confirmed"`
+}
+
+// baseConfidence scores how much the FROM line can be trusted, from 0 to 100.
+type baseConfidence struct {
+	Score    int          `json:"score"`
+	Evidence baseEvidence `json:"evidence"`
+	Reasons  []string     `json:"reasons,omitempty"`
+}
+
+// confidenceScorer awards points for one kind of evidence about the base image, and says why.
+type confidenceScorer func(evidence baseEvidence, baseImage string) (points int, reason string)
+
+// CONFIDENCE_SCORERS add up to the confidence score, which is capped at 100.
+var CONFIDENCE_SCORERS = []confidenceScorer{scoreLayerMatch, scoreDigestPinning, scoreRegistryConfirmation}
+
+// scoreLayerMatch trusts a complete chain of base layers most, and a build history naming the
+// base image more than a top layer alone.
+func scoreLayerMatch(evidence baseEvidence, baseImage string) (points int, reason string) {
+	switch {
+	case evidence.Source == BASE_SOURCE_LAYERS && evidence.MatchedLayers == evidence.BaseLayers && evidence.BaseLayers == 1:
+		return 60, fmt.Sprintf("the layer of %s starts the image", baseImage)
+	case evidence.Source == BASE_SOURCE_LAYERS && evidence.MatchedLayers == evidence.BaseLayers:
+		return 60, fmt.Sprintf("all %d layers of %s start the image", evidence.BaseLayers, baseImage)
+	case evidence.Source == BASE_SOURCE_LAYERS:
+		return 20, fmt.Sprintf("the top layer of %s is in the image but only %d of its %d layers start it", baseImage, evidence.MatchedLayers, evidence.BaseLayers)
+	case evidence.Source == BASE_SOURCE_LOOKUP && evidence.BaseLayers == 1:
+		return 60, fmt.Sprintf("--lookup-bases found %s in the registry and its layer starts the image", baseImage)
+	case evidence.Source == BASE_SOURCE_LOOKUP:
+		return 60, fmt.Sprintf("--lookup-bases found %s in the registry and all %d of its layers start the image", baseImage, evidence.BaseLayers)
+	case evidence.Source == BASE_SOURCE_HISTORY:
+		return 40, fmt.Sprintf("the build history names %s", baseImage)
+	case evidence.Source == BASE_SOURCE_ANNOTATION:
+		return 60, fmt.Sprintf("the image names %s as its base", baseImage)
+	case evidence.Source == BASE_SOURCE_PROVENANCE:
+		return 60, fmt.Sprintf("the build provenance names %s", baseImage)
+	case evidence.Source == BASE_SOURCE_FINGERPRINT:
+		return 40, fmt.Sprintf("the oldest steps of the image are those of %s", baseImage)
+	case evidence.Source == BASE_SOURCE_SCRATCH:
+		return 40, "the image has no parent and its first step adds its root filesystem"
+	}
+	return 0, "no local image shares the layers of the image"
+}
+
+func scoreDigestPinning(evidence baseEvidence, baseImage string) (points int, reason string) {
+	if evidence.BaseDigest == "" {
+		return 0, ""
+	}
+	return 20, fmt.Sprintf("%s can be pinned to %s", baseImage, evidence.BaseDigest)
+}
+
+func scoreRegistryConfirmation(evidence baseEvidence, baseImage string) (points int, reason string) {
+	if !evidence.RegistryConfirmed {
+		return 0, ""
+	}
+	return 20, fmt.Sprintf("the registry holds %s", baseImage)
+}
+
+// scoreBase runs every scorer over the evidence.
+func scoreBase(evidence baseEvidence, baseImage string) (confidence baseConfidence) {
+	confidence.Evidence = evidence
+	for _, scorer := range CONFIDENCE_SCORERS {
+		points, reason := scorer(evidence, baseImage)
+		confidence.Score += points
+		if reason != "" {
+			confidence.Reasons = append(confidence.Reasons, reason)
+		}
+	}
+	confidence.Score = min(confidence.Score, 100)
+	return confidence
+}
+
+// matchedBaseLayers counts the layers of the base image the image starts with.
+func matchedBaseLayers(layers []string, baseLayers []string) (matched int) {
+	for matched < min(len(layers), len(baseLayers)) && layers[matched] == baseLayers[matched] {
+		matched++
+	}
+	return matched
+}
+
+// repositoryDigest returns the digest of an image in the repository of a name, if it was pulled
+// from or pushed to that repository.
+func repositoryDigest(img image.Summary, imageName string) (digest string) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return ""
+	}
+	repoDigest := findRepoDigest(img, reference.TrimNamed(named))
+	if _, digest, ok := strings.Cut(repoDigest, "@"); ok {
+		return digest
+	}
+	return ""
+}
+
+// confidenceComment summarizes the confidence score above the FROM line.
+func confidenceComment(confidence *baseConfidence) (comment string) {
+	if confidence == nil {
+		return ""
+	}
+	comment = fmt.Sprintf("# Base image confidence: %d%%", confidence.Score)
+	if len(confidence.Reasons) > 0 {
+		comment += " - " + strings.Join(confidence.Reasons, ", ")
+	}
+	return comment
+}
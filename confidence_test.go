@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestScoreBase(t *testing.T) {
+	tests := []struct {
+		name      string
+		evidence  baseEvidence
+		baseImage string
+		score     int
+		reasons   []string
+	}{
+		{
+			name:      "a local base image",
+			evidence:  baseEvidence{Source: BASE_SOURCE_LAYERS, MatchedLayers: 3, BaseLayers: 3, BaseDigest: "sha256:2b0079146a74"},
+			baseImage: "python:3.12-slim",
+			score:     80,
+			reasons:   []string{"all 3 layers of python:3.12-slim start the image", "python:3.12-slim can be pinned to sha256:2b0079146a74"},
+		},
+		{
+			name:      "a base image --lookup-bases found",
+			evidence:  baseEvidence{Source: BASE_SOURCE_LOOKUP, MatchedLayers: 1, BaseLayers: 1, BaseDigest: "sha256:2b0079146a74", RegistryConfirmed: true},
+			baseImage: "alpine:latest",
+			score:     100,
+			reasons: []string{
+				"--lookup-bases found alpine:latest in the registry and its layer starts the image",
+				"alpine:latest can be pinned to sha256:2b0079146a74",
+				"the registry holds alpine:latest",
+			},
+		},
+		{
+			name:      "a partial layer match",
+			evidence:  baseEvidence{Source: BASE_SOURCE_LAYERS, MatchedLayers: 1, BaseLayers: 3},
+			baseImage: "debian:bookworm",
+			score:     20,
+			reasons:   []string{"the top layer of debian:bookworm is in the image but only 1 of its 3 layers start it"},
+		},
+		{
+			name:      "a fingerprint",
+			evidence:  baseEvidence{Source: BASE_SOURCE_FINGERPRINT},
+			baseImage: "alpine:3.20.0",
+			score:     40,
+			reasons:   []string{"the oldest steps of the image are those of alpine:3.20.0"},
+		},
+		{
+			name:    "no base image",
+			reasons: []string{"no local image shares the layers of the image"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			confidence := scoreBase(test.evidence, test.baseImage)
+			if confidence.Score != test.score {
+				t.Errorf("scoreBase() score = %d, want %d", confidence.Score, test.score)
+			}
+			if !slices.Equal(confidence.Reasons, test.reasons) {
+				t.Errorf("scoreBase() reasons = %q, want %q", confidence.Reasons, test.reasons)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings read from the dfimage configuration file.
+type Config struct {
+	// Header and Footer are text/template snippets added around every generated Dockerfile.
+	// They are executed against the JSON document, so {{ .Image }} or {{ .BaseImage }} can be used.
+	Header string `yaml:"header"`
+	Footer string `yaml:"footer"`
+	// Mirrors maps a registry to the mirror every request for its images goes to instead, e.g.
+	// docker.io to internal-mirror.example.com/dockerhub for air-gapped networks.
+	Mirrors map[string]string `yaml:"mirrors"`
+	// BaseCandidates are the images --lookup-bases tries after the Docker Hub official images, such
+	// as the base images of a company registry.
+	BaseCandidates []string `yaml:"base_candidates"`
+
+	header  *template.Template
+	footer  *template.Template
+	mirrors map[string]string
+}
+
+func defaultConfigPath() (path string) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, "dfimage", "config.yaml")
+}
+
+// loadConfig reads the configuration file at path, or the default one when path is empty. A
+// missing default file is not an error, a missing file that was asked for explicitly is.
+func loadConfig(path string) (config *Config, err error) {
+	config = &Config{}
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+		if path == "" {
+			return config, nil
+		}
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return config, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read the config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	err = decoder.Decode(config)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unable to parse the config file %s: %w", path, err)
+	}
+
+	config.header, err = parseConfigTemplate("header", config.Header)
+	if err != nil {
+		return nil, err
+	}
+	config.footer, err = parseConfigTemplate("footer", config.Footer)
+	if err != nil {
+		return nil, err
+	}
+	config.mirrors, err = parseMirrors(config.Mirrors)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range config.BaseCandidates {
+		if _, err := name.ParseReference(candidate); err != nil {
+			return nil, fmt.Errorf("invalid image %s in the base_candidates of the config file: %w", candidate, err)
+		}
+	}
+	return config, nil
+}
+
+// parseMirrors checks the mirrors of the config file and keys them by the registry name
+// go-containerregistry uses, so docker.io and index.docker.io are the same registry.
+func parseMirrors(mirrors map[string]string) (parsed map[string]string, err error) {
+	parsed = map[string]string{}
+	for registry, mirror := range mirrors {
+		upstream, err := name.NewRegistry(registry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid registry %s in the mirrors of the config file: %w", registry, err)
+		}
+		mirror = strings.TrimSuffix(mirror, "/")
+		if _, err := name.NewRepository(mirror + "/library/alpine"); err != nil {
+			return nil, fmt.Errorf("invalid mirror %q for %s in the config file - use a registry host with an optional path: %w", mirror, registry, err)
+		}
+		parsed[upstream.RegistryStr()] = mirror
+	}
+	return parsed, nil
+}
+
+// mirrorReference points a reference at the mirror configured for its registry, keeping the
+// repository below the path of the mirror. References to other registries are returned as they are.
+func mirrorReference(ref name.Reference, mirrors map[string]string) (mirrored name.Reference, err error) {
+	mirror, ok := mirrors[ref.Context().RegistryStr()]
+	if !ok {
+		return ref, nil
+	}
+	repository := mirror + "/" + ref.Context().RepositoryStr()
+	if digest, ok := ref.(name.Digest); ok {
+		return name.NewDigest(repository + "@" + digest.DigestStr())
+	}
+	return name.NewTag(repository + ":" + ref.Identifier())
+}
+
+func parseConfigTemplate(name string, text string) (tmpl *template.Template, err error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err = template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template in the config file: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func executeConfigTemplate(tmpl *template.Template, document jsonDocument) (text string, err error) {
+	if tmpl == nil {
+		return "", nil
+	}
+	var b strings.Builder
+	err = tmpl.Execute(&b, document)
+	if err != nil {
+		return "", fmt.Errorf("unable to render the %s template: %w", tmpl.Name(), err)
+	}
+	text = b.String()
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+)
+
+// newContainerdBackend reads images straight from a containerd namespace, for hosts running
+// nerdctl or Kubernetes without dockerd.
+func newContainerdBackend(opts *Options) (backend *indexBackend, err error) {
+	address := opts.ContainerdAddress
+	if address == "" {
+		address = DEFAULT_CONTAINERD_ADDRESS
+	}
+	err = checkOffline(opts.Offline, "containerd address", address)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := containerd.New(address, containerd.WithDefaultNamespace(opts.Namespace))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to containerd at %s - use --containerd-address to specify the path to containerd.sock: %w", address, err)
+	}
+	return &indexBackend{
+		source: fmt.Sprintf("the containerd namespace %s at %s", opts.Namespace, address),
+		load: func(ctx context.Context) (*ociImageIndex, error) {
+			return loadContainerdImages(ctx, cli, opts.Namespace, containerdPlatform(opts))
+		},
+	}, nil
+}
+
+// containerdPlatform matches the platform given with --platform, or else the one of this machine.
+func containerdPlatform(opts *Options) (platform platforms.MatchComparer) {
+	if opts.Platform != "" {
+		if requested, err := platforms.Parse(opts.Platform); err == nil {
+			return platforms.Only(requested)
+		}
+	}
+	return platforms.Default()
+}
+
+// loadContainerdImages reads the config of every image in the namespace. Images whose content is
+// only partly present, such as a manifest list pulled for another platform, are skipped.
+func loadContainerdImages(ctx context.Context, cli *containerd.Client, namespace string, platform platforms.MatchComparer) (index *ociImageIndex, err error) {
+	imageList, err := cli.ImageService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the images of the containerd namespace %s: %w", namespace, err)
+	}
+	store := cli.ContentStore()
+	index = newOciImageIndex()
+	for _, img := range imageList {
+		manifest, err := images.Manifest(ctx, store, img.Target, platform)
+		if errdefs.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read the image %s: %w", img.Name, err)
+		}
+		id := manifest.Config.Digest.String()
+		if _, ok := index.images[id]; !ok {
+			blob, err := content.ReadBlob(ctx, store, manifest.Config)
+			if errdefs.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("unable to read the config of the image %s: %w", img.Name, err)
+			}
+			var config dockerImage
+			err = json.Unmarshal(blob, &config)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse the config of the image %s: %w", img.Name, err)
+			}
+			entry := index.add(id, config)
+			for _, layer := range manifest.Layers {
+				if isForeignLayer(layer.MediaType) {
+					entry.manifest.ForeignLayers++
+				}
+			}
+			entry.manifest.Annotations = manifest.Annotations
+		}
+		index.addName(id, img.Name, img.Target.Digest.String())
+	}
+	index.sortNames()
+	return index, nil
+}
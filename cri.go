@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/pkg/dialer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// criImageInfo is the part of the verbose image status CRI-O and containerd both return.
+type criImageInfo struct {
+	ImageSpec *dockerImage `json:"imageSpec"`
+}
+
+// findCriEndpoint returns the first of the usual CRI sockets that exists, the same ones crictl tries.
+func findCriEndpoint() (endpoint string, err error) {
+	for _, endpoint := range CRI_ENDPOINTS {
+		if fileExists(criEndpointPath(endpoint)) {
+			return endpoint, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find a CRI socket - use --cri-endpoint to specify one, e.g. unix:///run/crio/crio.sock")
+}
+
+func criEndpointPath(endpoint string) (path string) {
+	return strings.TrimPrefix(strings.TrimPrefix(endpoint, "unix://"), "npipe://")
+}
+
+// newCriBackend reads images through the image service of a Kubernetes container runtime such as
+// CRI-O, containerd or cri-dockerd, so images already present on a node can be inspected without
+// a docker daemon.
+func newCriBackend(opts *Options) (backend *indexBackend, err error) {
+	endpoint := opts.CRIEndpoint
+	if endpoint == "" {
+		endpoint, err = findCriEndpoint()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = checkOffline(opts.Offline, "CRI endpoint", endpoint)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient("passthrough:///"+endpoint,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(dialer.ContextDialer),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the CRI endpoint %s: %w", endpoint, err)
+	}
+	cli := runtimeapi.NewImageServiceClient(conn)
+	return &indexBackend{
+		source: fmt.Sprintf("the CRI endpoint %s", endpoint),
+		load: func(ctx context.Context) (*ociImageIndex, error) {
+			return loadCriImages(ctx, cli, endpoint)
+		},
+	}, nil
+}
+
+// loadCriImages fetches the verbose status of every image, which carries the image config.
+func loadCriImages(ctx context.Context, cli runtimeapi.ImageServiceClient, endpoint string) (index *ociImageIndex, err error) {
+	response, err := cli.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the images of the CRI endpoint %s: %w", endpoint, err)
+	}
+	index = newOciImageIndex()
+	for _, img := range response.Images {
+		status, err := cli.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{
+			Image:   &runtimeapi.ImageSpec{Image: img.Id},
+			Verbose: true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch the status of the image %s: %w", img.Id, err)
+		}
+		var info criImageInfo
+		err = json.Unmarshal([]byte(status.Info["info"]), &info)
+		if err != nil || info.ImageSpec == nil {
+			return nil, fmt.Errorf("the CRI endpoint %s did not return the config of the image %s - the runtime must support verbose image status", endpoint, img.Id)
+		}
+		index.add(img.Id, *info.ImageSpec)
+		for _, tag := range img.RepoTags {
+			index.addName(img.Id, tag, "")
+		}
+		for _, digest := range img.RepoDigests {
+			index.addName(img.Id, digest, "")
+		}
+	}
+	index.sortNames()
+	return index, nil
+}
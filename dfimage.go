@@ -1,29 +1,93 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
-	"os/user"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
 
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/docker/cli/cli/connhelper"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	flags "github.com/jessevdk/go-flags"
-	"golang.org/x/sys/unix"
 )
 
-const DOCKER_API_VERSION = "1.39" // This is because of a client version error
 const VERSION = "0.1.1"
 
 type Options struct {
-	ImageName  string `short:"i" long:"image" description:"Specify the name of the image you want to inspect."`
-	SocketPath string `short:"s" long:"socket" description:"Specify the path to the docker.sock file."`
-	OutputFile string `short:"o" long:"outfile" description:"Write the output --outfile."`
-	Version    func() `short:"V" long:"version" description:"Output version information and exit."`
+	ImageNames        []string      `short:"i" long:"image" description:"Specify the name of the image you want to inspect. Can be repeated to inspect several images in one run. Use - to read a docker save stream from STDIN."`
+	SocketPath        string        `short:"s" long:"socket" description:"Specify the path to the docker.sock file (or the named pipe on Windows)."`
+	Runtime           string        `long:"runtime" description:"Talk to this container engine. Without it the Docker socket is looked for first and then the Podman one." choice:"docker" choice:"podman" choice:"containerd" choice:"cri"`
+	ContainerdAddress string        `long:"containerd-address" env:"CONTAINERD_ADDRESS" description:"Path to the containerd socket used with --runtime containerd."`
+	CRIEndpoint       string        `long:"cri-endpoint" env:"CONTAINER_RUNTIME_ENDPOINT" description:"The CRI endpoint used with --runtime cri, e.g. unix:///run/crio/crio.sock."`
+	Namespace         string        `long:"namespace" env:"CONTAINERD_NAMESPACE" description:"Read the images of this containerd namespace, e.g. k8s.io on Kubernetes nodes." default:"default"`
+	ImagesFrom        string        `long:"images-from" description:"Inspect the images listed in this file, one per line, or on STDIN with -."`
+	All               bool          `long:"all" description:"Inspect every image on the daemon or in the archive."`
+	Match             []string      `long:"match" description:"Inspect every image with a name matching this glob pattern, where * also matches /, e.g. 'myorg/*:prod-*'. Can be repeated."`
+	MatchRe           []string      `long:"match-re" description:"Inspect every image with a name matching this regular expression. Can be repeated."`
+	Concurrency       int           `long:"concurrency" description:"Inspect up to this many images of a batch at the same time." default:"1"`
+	Edit              bool          `long:"edit" description:"Open the reconstructed Dockerfile in $VISUAL or $EDITOR and check the edited file with the Dockerfile parser and linter before writing it."`
+	Update            bool          `long:"update" description:"Only rewrite an existing --outfile or --output-dir file when its content changed, and summarize what changed."`
+	Stats             bool          `long:"stats" description:"Report on STDERR how long each phase took and how many daemon API calls were made."`
+	CpuProfile        string        `long:"cpuprofile" hidden:"yes" description:"Write a Go CPU profile of the run to this file."`
+	MemProfile        string        `long:"memprofile" hidden:"yes" description:"Write a Go heap profile to this file at the end of the run."`
+	Force             bool          `long:"force" description:"Inspect every image of an --all or --output-dir batch, including those that have not changed since the last run."`
+	Input             string        `long:"input" description:"Read the image from this source, given with a transport like skopeo: docker://registry/repository:tag, docker-daemon:name:tag, docker-archive:/path/to/image.tar, oci:/path/to/layout[:tag] or dir:/path/to/directory. Use - to read a docker save stream from STDIN."`
+	Platform          string        `long:"platform" env:"DOCKER_DEFAULT_PLATFORM" description:"Pick this platform, e.g. linux/arm64, from multi-platform images and warn when the image is built for another one."`
+	Remote            bool          `long:"remote" description:"Fetch the image config straight from its registry instead of a local daemon, without pulling the image."`
+	Keychain          []string      `long:"keychain" description:"Resolve registry credentials in remote mode with this go-containerregistry keychain: docker, cloud, github or anonymous. Can be repeated to try several in order. (default: docker and cloud)" choice:"docker" choice:"cloud" choice:"github" choice:"anonymous"`
+	Host              string        `short:"H" long:"host" description:"Connect to this docker daemon, e.g. tcp://host:2376, ssh://user@host or unix:///path/to/docker.sock."`
+	Context           string        `short:"c" long:"context" description:"Connect to the endpoint of this docker context (see \"docker context ls\")."`
+	TLSCACert         string        `long:"tlscacert" description:"Trust certificates signed by this CA when connecting over TLS."`
+	TLSCert           string        `long:"tlscert" description:"Path to the TLS client certificate."`
+	TLSKey            string        `long:"tlskey" description:"Path to the TLS client key."`
+	OutputFile        string        `short:"o" long:"outfile" description:"Write the output --outfile."`
+	OutputDir         string        `long:"output-dir" description:"Write the output of every image to its own file in this directory."`
+	FilenameTemplate  string        `long:"filename-template" description:"Name the files written to --output-dir with this Go template using .Registry, .Repo and .Tag, e.g. '{{.Repo}}_{{.Tag}}.Dockerfile'."`
+	APIVersion        string        `long:"api-version" description:"Use this Docker API version instead of negotiating one with the daemon."`
+	Format            string        `short:"f" long:"format" description:"Output format." default:"dockerfile" choice:"dockerfile" choice:"json" choice:"markdown" choice:"sarif" choice:"k8s" choice:"systemd" choice:"jsonl"`
+	Bundle            string        `short:"b" long:"bundle" description:"Write the Dockerfile and its metadata to this .tar.gz archive."`
+	ConfigFile        string        `long:"config" env:"DFIMAGE_CONFIG" description:"Read settings from this YAML file instead of the default dfimage/config.yaml in the user config directory."`
+	PolicyFile        string        `long:"policy" description:"Evaluate the rules in this YAML policy file and exit with status 3 if any rule with error severity fails."`
+	AllowedBases      []string      `long:"allowed-bases" description:"Require the detected FROM image to match one of these patterns (comma-separated, or @file with one per line). Can be repeated."`
+	DeniedBases       []string      `long:"denied-bases" description:"Reject a detected FROM image matching any of these patterns (comma-separated, or @file with one per line). Can be repeated."`
+	BasesAction       string        `long:"bases-action" description:"Whether a base image outside the allowed or inside the denied bases fails the run or only warns." default:"fail" choice:"fail" choice:"warn"`
+	Baseline          string        `long:"baseline" description:"Accept the findings listed in this baseline file and apply its severity overrides."`
+	WriteBaseline     string        `long:"write-baseline" description:"Record the current findings in this baseline file so that only new findings fail later runs."`
+	Timeout           int           `short:"t" long:"timeout" description:"Give up if the docker daemon does not respond within this many seconds." default:"0"`
+	Deterministic     bool          `long:"deterministic" description:"Produce byte-identical output for the same image by omitting timestamps, absolute paths and host-dependent ordering."`
+	GroupLabels       bool          `long:"group-labels" description:"Merge the labels set by consecutive LABEL instructions into a single LABEL with a key on every line."`
+	PinDigests        bool          `long:"pin-digests" description:"Pin the FROM image to the repository digest of the base image, e.g. alpine:3.19@sha256:..., for reproducible rebuilds."`
+	CollapseWorkdirs  bool          `long:"collapse-workdirs" description:"Replace consecutive WORKDIR instructions with a single one setting the working directory they end in."`
+	AnnotateBuilders  bool          `long:"annotate-builders" description:"Add a comment naming the builder, such as BuildKit or docker commit, above the instructions it produced."`
+	Retries           int           `long:"retries" description:"Retry daemon calls that fail with a transient error this many times." default:"3"`
+	RetryBackoff      time.Duration `long:"retry-backoff" description:"Delay before the first retry, doubled for each further attempt." default:"500ms"`
+	RetryJitter       float64       `long:"retry-jitter" description:"Random fraction of the delay added to each retry." default:"0.2"`
+	NoCache           bool          `long:"no-cache" description:"Ignore any cached result and regenerate the output."`
+	LookupBases       bool          `long:"lookup-bases" description:"Look for a base image that is not available locally in the registries, among the Docker Hub official images and the base_candidates of the config file."`
+	Offline           bool          `long:"offline" description:"Guarantee that no network calls are made and fail if a requested feature would need the network."`
+	Version           func()        `short:"V" long:"version" description:"Output version information and exit."`
+
+	// incremental skips the images of a batch that have not changed since the last run
+	incremental bool
 }
 
 func fileExists(path string) (exists bool) {
@@ -33,35 +97,18 @@ func fileExists(path string) (exists bool) {
 	return true
 }
 
-func getSocket() (socketName string, err error) {
-	user, err := user.Current()
-	if err != nil {
-		return "", err
-	}
-	socketPaths := []string{
-		filepath.Join(user.HomeDir, ".rd", "docker.sock"),
-		filepath.Join(user.HomeDir, ".docker", "run", "docker.sock"),
-		"/var/run/docker.sock",
-	}
-
-	for _, socketPath := range socketPaths {
-		if fileExists(socketPath) {
-			return socketPath, nil
-		}
-	}
-
-	return "", errors.New("failed to find the docker socket - use --socket to specify the path to docker.sock")
-}
-
 func pathExistsAndIsWritable(path string) (err error) {
 	_, err = os.Stat(path)
 	if os.IsNotExist(err) {
 		return fmt.Errorf("the path %s does not exist - please choose another path", path)
 	}
-	ok := unix.Access(path, unix.W_OK)
-	if ok != nil {
+	// Probe by creating a file, which works the same way on every platform
+	f, err := os.CreateTemp(path, ".dfimage-*")
+	if err != nil {
 		return fmt.Errorf("the path %s is not writable - please choose another path", path)
 	}
+	f.Close()
+	os.Remove(f.Name())
 	return nil
 }
 
@@ -69,20 +116,135 @@ func standardizeSpaces(s string) string {
 	return strings.Join(strings.Fields(s), " ")
 }
 
+// getStep turns the created_by of a history step into its instruction. The classic builder and
+// Buildah mark the metadata steps with #(nop) and record a RUN step as its command line, while
+// BuildKit records every step as its instruction, with a # buildkit marker on those that change
+// the filesystem.
 func getStep(step string) string {
-	if strings.Contains(step, "#(nop)") {
-		stepBits := strings.Split(step, "#(nop) ")
-		return stepBits[1]
+	step = strings.TrimSpace(step)
+	if _, instruction, ok := strings.Cut(step, "#(nop) "); ok {
+		return strings.TrimSpace(instruction)
+	}
+	if buildkitStep.MatchString(step) {
+		return strings.TrimSpace(strings.TrimSuffix(step, BUILDKIT_MARKER))
+	}
+	return fmt.Sprintf("RUN %s", step)
+}
+
+// A heredoc a command reads, e.g. cat <<EOF > /etc/motd or <<-"EOF"
+var heredocStart = regexp.MustCompile(`<<-?\s*["']?(\w+)["']?`)
+
+// A flag of a RUN step, e.g. --mount=type=cache,target=/root/.cache, --network=none or
+// --security=insecure
+var runFlag = regexp.MustCompile(`^--(mount|network|security)=\S+\s+`)
+
+// runCommand formats the command line of a RUN step, keeping its flags in front. A long command
+// line is continued on a new line at every &&, while the lines of a command that has them come
+// from a heredoc and are kept. The shell is the one a SHELL step set, if any.
+func runCommand(command string, shell []string) string {
+	var flags string
+	for {
+		flag := runFlag.FindString(command)
+		if flag == "" {
+			break
+		}
+		flags += strings.TrimSpace(flag) + " "
+		command = command[len(flag):]
+	}
+	return flags + runScript(command, shell)
+}
+
+func runScript(command string, shell []string) string {
+	if len(shell) == 0 {
+		// Windows images run the steps with cmd rather than /bin/sh
+		if rest, ok := strings.CutPrefix(command, "cmd /S /C "); ok {
+			command = rest
+		} else {
+			command = strings.Replace(command, "/bin/sh -c ", "", 1)
+		}
 	} else {
-		return fmt.Sprintf("RUN %s", step)
+		command = strings.TrimPrefix(command, strings.Join(shell, " ")+" ")
 	}
+	if !strings.Contains(command, "\n") {
+		return strings.ReplaceAll(standardizeSpaces(command), " && ", " \\\n        && ")
+	}
+	lines := strings.Split(strings.TrimRight(command, " \t\n"), "\n")
+
+	// BuildKit passes a heredoc read by a command to the shell as it was written
+	if starts := heredocStart.FindAllStringSubmatch(lines[0], -1); len(starts) > 0 && strings.TrimSpace(lines[len(lines)-1]) == starts[len(starts)-1][1] {
+		return strings.Join(lines, "\n")
+	}
+	// Otherwise the lines are the script of RUN <<EOF, which BuildKit runs with the shell, and the
+	// delimiter is chosen so that no line of the script ends the heredoc early
+	delimiter := "EOF"
+	for i := 1; slices.Contains(lines, delimiter); i++ {
+		delimiter = fmt.Sprintf("EOF%d", i)
+	}
+	return fmt.Sprintf("<<%s\n%s\n%s", delimiter, strings.Join(lines, "\n"), delimiter)
 }
 
-func processOptions(opts Options) (imageId string, socketName string, outputFile string, err error) {
-	parser := flags.NewParser(&opts, flags.Default)
-	parser.Usage = `--image <image_name:tag> [--socket /path/to/docker.sock]
+// readImageNames reads the image names listed one per line in a file, or on STDIN for -, such as
+// the output of docker image ls --format '{{.Repository}}:{{.Tag}}'. Empty lines, comments and
+// untagged images are skipped.
+func readImageNames(path string) (imageNames []string, err error) {
+	var contents []byte
+	if path == "-" {
+		contents, err = io.ReadAll(os.Stdin)
+	} else {
+		contents, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the image list: %w", err)
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" || strings.Contains(line, "<none>") {
+			continue
+		}
+		imageNames = append(imageNames, line)
+	}
+	if len(imageNames) == 0 {
+		if path == "-" {
+			path = "STDIN"
+		}
+		return nil, fmt.Errorf("no images are listed in %s", path)
+	}
+	return imageNames, nil
+}
+
+func processOptions(opts *Options) (err error) {
+	parser := flags.NewParser(opts, flags.Default)
+	parser.Usage = `[--image] <image_name:tag> [--socket /path/to/docker.sock]
 	dfimage extracts a Dockerfile from the specified image name and prints it to STDOUT.`
-	if _, err := parser.Parse(); err != nil {
+	parser.SubcommandsOptional = true
+
+	cache := &cacheCommand{}
+	cache.Prune.opts = opts
+	parser.AddCommand("cache", "Manage the result cache", "List or remove the rendered results dfimage keeps between runs.", cache)
+
+	render := &renderCommand{opts: opts}
+	parser.AddCommand("render", "Re-render a bundle", "Render a bundle written with --bundle in any output format, without access to the daemon or the image.", render)
+
+	matrix := &matrixCommand{opts: opts}
+	parser.AddCommand("matrix", "Compare images pairwise", "Reconstruct every image and print how similar each pair is, by the layers they share and the percentage of identical instructions.", matrix)
+
+	similar := &similarCommand{opts: opts}
+	parser.AddCommand("similar", "Find the local images most similar to an image", "Rank the other local images by the layers they share with the image given with --image and the percentage of identical instructions.", similar)
+
+	timeline := &timelineCommand{opts: opts}
+	parser.AddCommand("timeline", "Show how a tag changed over time", "Reconstruct every version of a tag, oldest first, and print the instructions that changed between consecutive versions. The versions are the digests given with --digest or, locally, the images previously pulled from the repository.", timeline)
+
+	sync := &syncCommand{opts: opts}
+	parser.AddCommand("sync", "Mirror the images to a directory", "Write the output of every image, or of those selected with --match or --match-re, to its own file in --outdir, and remove the files of images that are gone. Unchanged files are left untouched, so sync can be run periodically.", sync)
+
+	metrics := &metricsCommand{opts: opts}
+	parser.AddCommand("metrics", "Export image metrics for Prometheus", "Reconstruct every image, or those selected with --match or --match-re, and export its size, layer count, age, base image and policy findings as Prometheus gauges, served at /metrics or printed once with --once.", metrics)
+
+	parser.AddCommand("backends", "List the features of every backend", "Print which features every image source supports: the docker or Podman daemon, archives, OCI layouts, image directories, registries, containerd and the CRI.", &backendsCommand{})
+
+	args, err := parser.Parse()
+	if err != nil {
 		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
 			os.Exit(0)
 		} else {
@@ -90,65 +252,348 @@ func processOptions(opts Options) (imageId string, socketName string, outputFile
 		}
 	}
 
-	if opts.ImageName == "" {
-		return "", "", "", fmt.Errorf("missing required option --image")
-	} else {
-		imageId = opts.ImageName
+	// A subcommand has already run
+	if parser.Active != nil {
+		os.Exit(0)
 	}
 
-	if opts.SocketPath == "" {
-		socketName, err = getSocket()
+	// Images can also be given as arguments, like with docker and podman
+	opts.ImageNames = append(opts.ImageNames, args...)
+
+	if opts.ImagesFrom == "-" && (opts.Input == "-" || slices.Contains(opts.ImageNames, "-")) {
+		return fmt.Errorf("--images-from - and an archive read from STDIN cannot be used together")
+	}
+	if opts.ImagesFrom != "" {
+		imageNames, err := readImageNames(opts.ImagesFrom)
 		if err != nil {
-			return "", "", "", err
+			return err
 		}
-	} else {
-		socketName = opts.SocketPath
+		opts.ImageNames = append(opts.ImageNames, imageNames...)
 	}
 
-	if opts.OutputFile != "" {
-		var path = ""
-		if strings.Contains(opts.OutputFile, "/") {
-			// The option includes a path
-			path = filepath.Dir(opts.OutputFile)
-		} else {
-			// There is no path here, we test cwd
-			path, err = os.Getwd()
-			if err != nil {
-				return "", "", "", fmt.Errorf("unable to detect the current working directory")
+	// -i - reads the archive from STDIN like --input -
+	if slices.Contains(opts.ImageNames, "-") {
+		if opts.Input != "" || len(opts.ImageNames) > 1 {
+			return fmt.Errorf("--image - reads an archive from STDIN and cannot be used with --input or other images")
+		}
+		opts.ImageNames = nil
+		opts.Input = "-"
+	}
+
+	// The registry and daemon transports name an image in a source the other options already select
+	if opts.Input != "" {
+		transport, location, err := parseInput(opts.Input)
+		if err != nil {
+			return err
+		}
+		if transport == "docker" || transport == "docker-daemon" {
+			if len(opts.ImageNames) > 0 {
+				return fmt.Errorf("--image cannot be used with --input %s, which names the image itself", opts.Input)
 			}
+			opts.ImageNames = []string{location}
+			opts.Remote = transport == "docker"
+			opts.Input = ""
+		}
+	}
+
+	// An archive holding a single image needs no --image
+	selecting := opts.All || len(opts.Match) > 0 || len(opts.MatchRe) > 0
+	if len(opts.ImageNames) == 0 && opts.Input == "" && !selecting {
+		return fmt.Errorf("missing the image to inspect - give it as an argument or with --image")
+	}
+
+	if opts.All && (len(opts.Match) > 0 || len(opts.MatchRe) > 0) {
+		return fmt.Errorf("--all inspects every image and cannot be used with --match or --match-re")
+	}
+
+	if selecting && len(opts.ImageNames) > 0 {
+		return fmt.Errorf("--all, --match and --match-re select the images and cannot be used with --image or image arguments")
+	}
+
+	if selecting {
+		err = requireFeature(opts, FEATURE_LIST, "give the images with --image instead of --all, --match or --match-re")
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.PinDigests {
+		err = requireFeature(opts, FEATURE_REPO_DIGESTS, "run without --pin-digests")
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, expr := range opts.MatchRe {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("--match-re %s is not a valid regular expression: %w", expr, err)
+		}
+	}
+
+	batch := len(opts.ImageNames) > 1 || selecting
+	// Only a batch of --all or one that writes to --output-dir skips its unchanged images, whose
+	// output is then already on hand
+	skipping := batch && (opts.All || opts.OutputDir != "")
+	if opts.Force && !skipping {
+		return fmt.Errorf("--force inspects the unchanged images a batch skips - use it with --all or with several images and --output-dir")
+	}
+	opts.incremental = skipping && !opts.Force && !opts.NoCache
+
+	if batch && (opts.OutputFile != "" || opts.Bundle != "" || opts.WriteBaseline != "") {
+		return fmt.Errorf("--outfile, --bundle and --write-baseline write a single image and cannot be used with several images - use --output-dir instead")
+	}
+
+	if opts.OutputDir != "" && (opts.OutputFile != "" || opts.Bundle != "") {
+		return fmt.Errorf("--output-dir cannot be used together with --outfile or --bundle")
+	}
+
+	if opts.Edit && (batch || opts.OutputDir != "") {
+		return fmt.Errorf("--edit opens the Dockerfile of a single image and cannot be used with several images or --output-dir")
+	}
+
+	if opts.Edit && opts.Format != "dockerfile" {
+		return fmt.Errorf("--edit edits a Dockerfile and cannot be used with --format %s", opts.Format)
+	}
+
+	if opts.Edit && (opts.Input == "-" || opts.ImagesFrom == "-") {
+		return fmt.Errorf("--edit needs STDIN for the editor and cannot read the image or image names from it")
+	}
+
+	if opts.Update && opts.OutputFile == "" && opts.OutputDir == "" {
+		return fmt.Errorf("--update rewrites existing output files and needs --outfile or --output-dir")
+	}
+
+	if opts.FilenameTemplate != "" && opts.OutputDir == "" {
+		return fmt.Errorf("--filename-template names the files written to --output-dir and needs it")
+	}
+
+	if opts.OutputDir != "" {
+		if _, err := parseFilenameTemplate(opts.FilenameTemplate, opts.Format); err != nil {
+			return err
+		}
+		err = os.MkdirAll(opts.OutputDir, 0755)
+		if err != nil {
+			return fmt.Errorf("unable to create the output directory: %w", err)
+		}
+		err = pathExistsAndIsWritable(opts.OutputDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Platform != "" {
+		if _, err := platforms.Parse(opts.Platform); err != nil {
+			return fmt.Errorf("--platform %s is not a valid platform - use e.g. linux/amd64 or linux/arm/v7: %w", opts.Platform, err)
 		}
-		err = pathExistsAndIsWritable(path)
+		err = requireFeature(opts, FEATURE_PLATFORMS, "pull the image for the platform first, and run without --platform or DOCKER_DEFAULT_PLATFORM")
 		if err != nil {
-			return "", "", "", err
+			return err
+		}
+	}
+
+	if opts.Concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+
+	if opts.Timeout < 0 {
+		return fmt.Errorf("--timeout must not be negative")
+	}
+
+	if opts.Host != "" && opts.SocketPath != "" {
+		return fmt.Errorf("--host and --socket cannot be used together")
+	}
+
+	if opts.Context != "" && (opts.Host != "" || opts.SocketPath != "") {
+		return fmt.Errorf("--context cannot be used together with --host or --socket")
+	}
+
+	if opts.Input != "" && (opts.Remote || opts.Runtime != "" || opts.Host != "" || opts.SocketPath != "" || opts.Context != "") {
+		return fmt.Errorf("--input cannot be used together with --remote, --runtime, --host, --socket or --context")
+	}
+
+	if opts.Remote && (opts.Runtime != "" || opts.Host != "" || opts.SocketPath != "" || opts.Context != "") {
+		return fmt.Errorf("--remote cannot be used together with --runtime, --host, --socket or --context")
+	}
+
+	if len(opts.Keychain) > 0 && !opts.Remote {
+		return fmt.Errorf("--keychain selects the registry credentials of remote mode and needs --remote or a docker:// input")
+	}
+
+	if slices.Contains(opts.Keychain, "anonymous") && len(opts.Keychain) > 1 {
+		return fmt.Errorf("--keychain anonymous sends no credentials and cannot be combined with other keychains")
+	}
+
+	if opts.Remote && opts.Offline {
+		return fmt.Errorf("--remote fetches the image from its registry and cannot be used with --offline")
+	}
+
+	if opts.LookupBases && opts.Offline {
+		return fmt.Errorf("--lookup-bases queries the registries and cannot be used with --offline")
+	}
+
+	if opts.Runtime == "containerd" && (opts.Host != "" || opts.SocketPath != "" || opts.Context != "") {
+		return fmt.Errorf("--host, --socket and --context cannot be used with --runtime containerd - use --containerd-address instead")
+	}
+
+	if opts.Runtime == "cri" && (opts.Host != "" || opts.SocketPath != "" || opts.Context != "") {
+		return fmt.Errorf("--host, --socket and --context cannot be used with --runtime cri - use --cri-endpoint instead")
+	}
+
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return fmt.Errorf("--tlscert and --tlskey must be given together")
+	}
+
+	if opts.Retries < 0 {
+		return fmt.Errorf("--retries must not be negative")
+	}
+
+	if opts.RetryJitter < 0 || opts.RetryJitter > 1 {
+		return fmt.Errorf("--retry-jitter must be between 0 and 1")
+	}
+
+	if opts.WriteBaseline != "" && opts.PolicyFile == "" && len(opts.AllowedBases) == 0 && len(opts.DeniedBases) == 0 {
+		return fmt.Errorf("--write-baseline needs --policy, --allowed-bases or --denied-bases")
+	}
+
+	for _, outputPath := range []string{opts.OutputFile, opts.Bundle, opts.WriteBaseline} {
+		if outputPath != "" {
+			err = checkOutputPath(outputPath)
+			if err != nil {
+				return err
+			}
 		}
 	}
-	return imageId, socketName, outputFile, nil
+	return nil
 }
 
-func getLayersWithImages(cli *client.Client, imageList []image.Summary) (layersWithImages map[string]string) {
-	layersWithImages = make(map[string]string)
-	for _, img := range imageList {
-		inspect, _, err := cli.ImageInspectWithRaw(context.Background(), img.ID)
+// checkOutputPath makes sure the directory a file will be written to exists and is writable.
+func checkOutputPath(outputPath string) (err error) {
+	path := filepath.Dir(outputPath)
+	if path == "." {
+		// There is no path here, we test cwd
+		path, err = os.Getwd()
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("unable to detect the current working directory")
 		}
-		layers := inspect.RootFS.Layers
-		if len(layers) > 0 {
-			lastLayerId := layers[len(layers)-1]
-			layersWithImages[lastLayerId] = img.RepoTags[0]
+	}
+	return pathExistsAndIsWritable(path)
+}
+
+// checkOffline refuses endpoints that would be reached over the network when --offline is set. An
+// endpoint without a scheme is the path of a socket or a named pipe. The kind names the endpoint
+// in the error, e.g. docker host.
+func checkOffline(offline bool, kind string, host string) (err error) {
+	if !offline {
+		return nil
+	}
+	if strings.HasPrefix(host, "unix://") || strings.HasPrefix(host, "npipe://") || !strings.Contains(host, "://") {
+		return nil
+	}
+	return fmt.Errorf("--offline is set but the %s %s would be reached over the network", kind, host)
+}
+
+// extraction is the structured result of reconstructing one image.
+type extraction struct {
+	Image        string   `json:"image"`
+	ImageID      string   `json:"image_id"`
+	RepoTags     []string `json:"repo_tags"`
+	RepoDigests  []string `json:"repo_digests"`
+	Created      string   `json:"created"`
+	OS           string   `json:"os"`
+	Architecture string   `json:"architecture"`
+	Variant      string   `json:"variant,omitempty"`
+	// ForeignLayers counts the layers, such as Windows base layers, that are not distributed with the image
+	ForeignLayers int `json:"foreign_layers,omitempty"`
+	// ManifestSchema1 marks an image read from a Docker schema 1 manifest, whose history is less detailed
+	ManifestSchema1 bool   `json:"manifest_schema1,omitempty"`
+	BaseImage       string `json:"base_image,omitempty"`
+	// BaseLookupMissed marks an image none of the --lookup-bases candidates starts
+	BaseLookupMissed bool `json:"base_lookup_missed,omitempty"`
+	// BaseConfidence scores the FROM line and lists its evidence
+	BaseConfidence *baseConfidence `json:"base_confidence,omitempty"`
+	Config         imageConfig     `json:"config"`
+	Instructions   []string        `json:"instructions"`
+	// Builders names the builder of every instruction: buildkit, classic, buildah or commit, and
+	// nothing for the FROM line
+	Builders []string `json:"builders,omitempty"`
+	// Contents holds the content hash, e.g. file:d0764a71..., of every COPY or ADD instruction whose
+	// source the history only records as a hash, and nothing for the other instructions
+	Contents []string `json:"contents,omitempty"`
+}
+
+// imageConfig is the part of the image configuration that describes how containers run.
+type imageConfig struct {
+	User         string            `json:"user,omitempty"`
+	Env          []string          `json:"env,omitempty"`
+	Entrypoint   []string          `json:"entrypoint,omitempty"`
+	Cmd          []string          `json:"cmd,omitempty"`
+	WorkingDir   string            `json:"working_dir,omitempty"`
+	ExposedPorts []string          `json:"exposed_ports,omitempty"`
+	Volumes      []string          `json:"volumes,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	StopSignal   string            `json:"stop_signal,omitempty"`
+	Shell        []string          `json:"shell,omitempty"`
+}
+
+func newImageConfig(config *container.Config) (result imageConfig) {
+	if config == nil {
+		return result
+	}
+	result = imageConfig{
+		User:       config.User,
+		Env:        config.Env,
+		Entrypoint: config.Entrypoint,
+		Cmd:        config.Cmd,
+		WorkingDir: config.WorkingDir,
+		Labels:     config.Labels,
+		StopSignal: config.StopSignal,
+		Shell:      config.Shell,
+	}
+	for port := range config.ExposedPorts {
+		result.ExposedPorts = append(result.ExposedPorts, string(port))
+	}
+	slices.Sort(result.ExposedPorts)
+	for volume := range config.Volumes {
+		result.Volumes = append(result.Volumes, volume)
+	}
+	slices.Sort(result.Volumes)
+	return result
+}
+
+// normalizeImageName returns the fully qualified form of an image name, so that alpine:3.19 and
+// docker.io/library/alpine:3.19 compare equal. Podman tags the images it builds with a localhost/
+// prefix, which is dropped so that they can still be found by their short name. A name with both a
+// tag and a digest is reduced to the digest, which is what the daemon and crane resolve it by.
+func normalizeImageName(name string) string {
+	name = strings.TrimPrefix(name, "localhost/")
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return name
+	}
+	if canonical, ok := named.(reference.Canonical); ok {
+		if withDigest, err := reference.WithDigest(reference.TrimNamed(named), canonical.Digest()); err == nil {
+			return withDigest.String()
 		}
 	}
-	return layersWithImages
+	return reference.TagNameOnly(named).String()
+}
+
+// hasImageName reports whether one of the tags or digests of an image is the normalized name.
+func hasImageName(img image.Summary, normalized string) bool {
+	return slices.ContainsFunc(append(slices.Clip(img.RepoTags), img.RepoDigests...), func(imageName string) bool {
+		return normalizeImageName(imageName) == normalized
+	})
 }
 
 func findImageFromImageList(imageList []image.Summary, imageId string, repoTag string) (myImage image.Summary, err error) {
 	var imageFound = false
+	wanted := normalizeImageName(repoTag)
 	for _, img := range imageList {
 		imageBits := strings.Split(img.ID, ":")
-		if strings.HasPrefix(strings.ToLower(imageBits[1]), imageId) {
+		if strings.HasPrefix(strings.ToLower(imageBits[len(imageBits)-1]), imageId) {
 			myImage = img
 			imageFound = true
-		} else if repoTag != "" && slices.Contains(img.RepoTags, repoTag) {
+		} else if repoTag != "" && hasImageName(img, wanted) {
 			myImage = img
 			imageFound = true
 		}
@@ -160,66 +605,625 @@ func findImageFromImageList(imageList []image.Summary, imageId string, repoTag s
 	return myImage, nil
 }
 
-func getFromImage(cli *client.Client, myImage image.Summary, layersWithImages map[string]string) (fromImage string) {
-	// Need to return the error here
-	var possibleFromImage string
-	inspect, _, err := cli.ImageInspectWithRaw(context.Background(), myImage.ID)
+// parseImageHistory turns the history of an image into instructions, newest first, up to baseStep,
+// the newest step of the base image, when it is known.
+func parseImageHistory(ctx context.Context, cli imageBackend, myImage image.Summary, config *container.Config, baseStep string) (dockerCommands []string, builders []string, contents []string, historyBase string, err error) {
+	var buildArgs [][]string
+	var volumes []string
+	// The image config resolves what the history records ambiguously
+	if config == nil {
+		config = &container.Config{}
+	}
+	finalCmd, finalEntrypoint := config.Cmd, config.Entrypoint
+
+	imageHistory, err := cli.ImageHistory(ctx, myImage.ID)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, nil, nil, "", fmt.Errorf("unable to fetch the history of the image %s: %w", myImage.ID, err)
 	}
-	layers := inspect.RootFS.Layers
-	if len(layers) > 0 {
-		for _, layerId := range layers {
-			_, ok := layersWithImages[layerId]
-			if ok {
-				possibleFromImage = layersWithImages[layerId]
-				if possibleFromImage == myImage.RepoTags[0] {
-					continue
+	buildah := isBuildahImage(myImage.Labels, imageHistory)
+
+	shells := stepShells(imageHistory, config.Shell)
+	for i, imageEvent := range imageHistory {
+		if baseStep != "" && imageEvent.CreatedBy == baseStep {
+			break
+		}
+		// Podman leaves created_by empty for layers it has no command for
+		if strings.TrimSpace(imageEvent.CreatedBy) != "" {
+			sanitizedCommand := getStep(imageEvent.CreatedBy)
+			var stepArgs []string
+			if command, ok := strings.CutPrefix(sanitizedCommand, "RUN "); ok {
+				stepArgs, command = cutBuildArgs(command, shells[i])
+				sanitizedCommand = "RUN " + runCommand(command, shells[i])
+			} else if args, ok := strings.CutPrefix(sanitizedCommand, "ENV "); ok {
+				sanitizedCommand = formatEnv(args, config.Env)
+			} else if args, ok := strings.CutPrefix(sanitizedCommand, "LABEL "); ok {
+				sanitizedCommand = formatLabel(args, config.Labels)
+			} else if args, ok := strings.CutPrefix(sanitizedCommand, "CMD "); ok {
+				// The history is newest first, so only the first CMD is the one in the config
+				sanitizedCommand = formatCommand("CMD", args, finalCmd, shells[i])
+				finalCmd = nil
+			} else if args, ok := strings.CutPrefix(sanitizedCommand, "ENTRYPOINT "); ok {
+				sanitizedCommand = formatCommand("ENTRYPOINT", args, finalEntrypoint, shells[i])
+				finalEntrypoint = nil
+			} else if args, ok := strings.CutPrefix(sanitizedCommand, "EXPOSE "); ok {
+				sanitizedCommand = formatExpose(args)
+			} else if strings.HasPrefix(sanitizedCommand, "SHELL ") {
+				sanitizedCommand = "SHELL " + execForm(shells[i])
+			} else if keyword, args, ok := strings.Cut(sanitizedCommand, " "); ok && (keyword == "COPY" || keyword == "ADD") {
+				var content string
+				sanitizedCommand, content = formatCopy(keyword, args)
+				if content != "" {
+					contents = append(contents, content)
 				}
-				fromImage = layersWithImages[layerId]
-				break
+			} else if args, ok := strings.CutPrefix(sanitizedCommand, "VOLUME "); ok {
+				paths := volumePaths(args, config.Volumes)
+				volumes = append(volumes, paths...)
+				sanitizedCommand = formatVolume(paths)
+			} else {
+				sanitizedCommand = standardizeSpaces(sanitizedCommand)
 			}
+			dockerCommands = append(dockerCommands, sanitizedCommand)
+			builders = append(builders, historyBuilder(imageEvent, buildah))
+			buildArgs = append(buildArgs, stepArgs)
+		}
+		// Buildah records the base of a build in the comment of its first step, which marks where
+		// the base image's own history starts even when that image is not available locally
+		if baseStep == "" && strings.HasPrefix(imageEvent.Comment, "FROM ") {
+			historyBase = strings.TrimSpace(strings.TrimPrefix(imageEvent.Comment, "FROM "))
+			break
 		}
 	}
-	return fromImage
+	dockerCommands, builders = declareBuildArgs(dockerCommands, builders, buildArgs)
+	dockerCommands, builders = exposeMissingPorts(dockerCommands, builders, config.ExposedPorts)
+	dockerCommands, builders = declareMissingVolumes(dockerCommands, builders, volumes, config.Volumes)
+	dockerCommands, builders = setWorkingDir(dockerCommands, builders, config.WorkingDir)
+	if len(config.Shell) > 0 {
+		dockerCommands, builders = setInstruction(dockerCommands, builders, "SHELL", execForm(config.Shell))
+	}
+	dockerCommands, builders = setInstruction(dockerCommands, builders, "USER", config.User)
+	dockerCommands, builders = setInstruction(dockerCommands, builders, "STOPSIGNAL", config.StopSignal)
+	return dockerCommands, builders, contents, historyBase, nil
 }
 
-func parseImageHistory(cli *client.Client, myImage image.Summary, fromImage string) (dockerCommands []string) {
-	// Need to return the error here
-	var fromLastCreatedBy string
-
-	imageHistory, err := cli.ImageHistory(context.Background(), myImage.RepoTags[0])
+// newestStep returns the created_by of the newest step of an image, where the history of an image
+// built on it is cut.
+func newestStep(ctx context.Context, cli imageBackend, imageId string) (step string, err error) {
+	imageHistory, err := cli.ImageHistory(ctx, imageId)
 	if err != nil {
-		fmt.Println(err)
+		return "", fmt.Errorf("unable to fetch the history of the image %s: %w", imageId, err)
+	}
+	if len(imageHistory) > 0 {
+		step = imageHistory[0].CreatedBy
+	}
+	return step, nil
+}
+
+// The build arguments a RUN step was run with prefix its command line, e.g.
+// |2 VERSION=1.2 FOO=bar /bin/sh -c make
+var buildArgsPrefix = regexp.MustCompile(`^\|(\d+)\s+`)
+
+var buildArg = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)=(\S*)\s+`)
+
+// The name of a build argument, at the start of the prefix or after a space
+var buildArgName = regexp.MustCompile(`(?:^|\s)([A-Za-z_][A-Za-z0-9_]*)=`)
+
+// cutBuildArgs removes the build arguments from the command line of a RUN step and returns them as
+// NAME=value. The builders write the values unquoted, so when the shell of the step is found the
+// arguments end where it starts and a value may contain spaces; otherwise every value ends at the
+// next space. A prefix that cannot be parsed is left in place, as are the flags of the step, which
+// may come before it.
+func cutBuildArgs(command string, shell []string) (buildArgs []string, rest string) {
+	var flags string
+	for flag := runFlag.FindString(command); flag != ""; flag = runFlag.FindString(command[len(flags):]) {
+		flags += flag
+	}
+	match := buildArgsPrefix.FindStringSubmatch(command[len(flags):])
+	if match == nil {
+		return nil, command
+	}
+	count, _ := strconv.Atoi(match[1])
+	rest = command[len(flags)+len(match[0]):]
+
+	shells := DEFAULT_SHELLS
+	if len(shell) > 0 {
+		shells = [][]string{shell}
+	}
+	for _, shell := range shells {
+		prefix := strings.Join(shell, " ") + " "
+		start := strings.Index(" "+rest, " "+prefix)
+		if start < 0 {
+			continue
+		}
+		names := buildArgName.FindAllStringSubmatchIndex(rest[:start], -1)
+		if len(names) != count || (count > 0 && names[0][0] != 0) {
+			return nil, command
+		}
+		for i, name := range names {
+			end := start - 1
+			if i+1 < len(names) {
+				end = names[i+1][0]
+			}
+			buildArgs = append(buildArgs, rest[name[2]:name[3]]+"="+rest[name[1]:max(end, name[1])])
+		}
+		return buildArgs, flags + rest[start:]
+	}
+
+	for range count {
+		arg := buildArg.FindStringSubmatch(rest)
+		if arg == nil {
+			return nil, command
+		}
+		buildArgs = append(buildArgs, arg[1]+"="+arg[2])
+		rest = rest[len(arg[0]):]
+	}
+	return buildArgs, flags + rest
+}
+
+// declareBuildArgs adds an ARG instruction before every RUN step for the build arguments it used
+// that no earlier ARG step declares, as the history of the classic builder may lack them. The
+// instructions are in the order of the history, newest first, so they are walked backwards.
+func declareBuildArgs(dockerCommands []string, builders []string, buildArgs [][]string) (commands []string, commandBuilders []string) {
+	declared := map[string]bool{}
+	for i := len(dockerCommands) - 1; i >= 0; i-- {
+		if arg, ok := strings.CutPrefix(dockerCommands[i], "ARG "); ok {
+			name, _, _ := strings.Cut(arg, "=")
+			declared[name] = true
+		}
+		for _, arg := range buildArgs[i] {
+			name, _, _ := strings.Cut(arg, "=")
+			if !declared[name] {
+				declared[name] = true
+				commands = append(commands, "ARG "+arg)
+				commandBuilders = append(commandBuilders, builders[i])
+			}
+		}
+		commands = append(commands, dockerCommands[i])
+		commandBuilders = append(commandBuilders, builders[i])
+	}
+	slices.Reverse(commands)
+	slices.Reverse(commandBuilders)
+	return commands, commandBuilders
+}
+
+// exitWithError prints err and exits, reporting a cancelled context as an interruption.
+func exitWithError(ctx context.Context, err error) {
+	PROGRESS.clear()
+	PROFILING.stop()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		fmt.Println(context.Cause(ctx))
 		os.Exit(1)
+	} else if ctx.Err() != nil {
+		fmt.Println("interrupted - aborting")
+		os.Exit(130)
 	}
+	fmt.Println(err)
+	os.Exit(1)
+}
 
-	if fromImage != "" {
-		fromImageHistory, err := cli.ImageHistory(context.Background(), fromImage)
+// newContext returns the context shared by all daemon calls. It is cancelled on Ctrl-C or SIGTERM
+// and, when --timeout is set, once the timeout expires.
+func newContext(opts *Options) (ctx context.Context, cancel context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if opts.Timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancelTimeout := context.WithTimeoutCause(ctx, time.Duration(opts.Timeout)*time.Second, fmt.Errorf("the docker daemon did not respond within %d seconds", opts.Timeout))
+	return ctx, func() {
+		cancelTimeout()
+		stop()
+	}
+}
+
+// findSocket looks for the API socket of the given runtime. Without one the Docker socket is
+// preferred and Podman's is only used when no Docker socket exists.
+func findSocket(runtime string) (socketPath string, detected string, err error) {
+	if runtime == "podman" {
+		socketPath, err = getPodmanSocket()
+		return socketPath, "podman", err
+	}
+	socketPath, err = getSocket()
+	if err == nil || runtime == "docker" {
+		return socketPath, "docker", err
+	}
+	if podmanSocket, podmanErr := getPodmanSocket(); podmanErr == nil {
+		return podmanSocket, "podman", nil
+	}
+	return "", runtime, err
+}
+
+func newDockerClient(opts *Options) (cli imageBackend, err error) {
+	var clientOpts []client.Opt
+	host := os.Getenv("DOCKER_HOST")
+
+	// Like the docker CLI, DOCKER_HOST wins over the current context but not over --context
+	contextName := opts.Context
+	if contextName == "" && opts.Runtime != "podman" && opts.Host == "" && opts.SocketPath == "" && host == "" {
+		contextName = currentDockerContext()
+	}
+
+	switch {
+	case opts.Host != "":
+		host = opts.Host
+		clientOpts = append(clientOpts, client.WithHost(host))
+	case opts.SocketPath != "":
+		host = socketHost(opts.SocketPath)
+		clientOpts = append(clientOpts, client.WithHost(host))
+	case contextName != "" && contextName != "default":
+		endpoint, err := resolveDockerContext(contextName)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		for _, fromImageEvent := range fromImageHistory {
-			fromLastCreatedBy = fromImageEvent.CreatedBy
-			break
+		host = endpoint.Host
+		clientOpts, err = endpoint.clientOpts()
+		if err != nil {
+			return nil, err
+		}
+	case host != "":
+		// Honor DOCKER_HOST, DOCKER_TLS_VERIFY and DOCKER_CERT_PATH the same way the docker CLI does
+		clientOpts = append(clientOpts, client.FromEnv)
+	default:
+		opts.SocketPath, opts.Runtime, err = findSocket(opts.Runtime)
+		if err != nil {
+			return nil, err
+		}
+		host = socketHost(opts.SocketPath)
+		clientOpts = append(clientOpts, client.WithHost(host))
+	}
+	if strings.HasPrefix(host, "ssh://") {
+		// Tunnel the API through "ssh <host> docker system dial-stdio" the same way the docker CLI does
+		helper, err := connhelper.GetConnectionHelper(host)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to %s: %w", host, err)
+		}
+		clientOpts = []client.Opt{
+			client.WithHTTPClient(&http.Client{Transport: &http.Transport{DialContext: helper.Dialer}}),
+			client.WithHost(helper.Host),
+			client.WithDialContext(helper.Dialer),
+			client.WithVersionFromEnv(),
+		}
+	}
+	if opts.TLSCACert != "" || opts.TLSCert != "" || opts.TLSKey != "" {
+		clientOpts = append(clientOpts, client.WithTLSClientConfig(opts.TLSCACert, opts.TLSCert, opts.TLSKey))
+	}
+	err = checkOffline(opts.Offline, "docker host", host)
+	if err != nil {
+		return nil, err
+	}
+	if opts.APIVersion != "" {
+		clientOpts = append(clientOpts, client.WithVersion(opts.APIVersion))
+	} else {
+		clientOpts = append(clientOpts, client.WithAPIVersionNegotiation())
+	}
+	apiClient, err := client.NewClientWithOpts(clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the docker client: %w", err)
+	}
+	if host == "" {
+		host = apiClient.DaemonHost()
+	}
+	cli = &dockerClient{
+		Client: apiClient,
+		host:   host,
+		retry: retryPolicy{
+			Attempts: opts.Retries,
+			Backoff:  opts.RetryBackoff,
+			Jitter:   opts.RetryJitter,
+		},
+	}
+	return cli, nil
+}
+
+// layerIndex finds the FROM image of an image among the other local images, by the top layer they
+// end with. Only the images that could be the base are inspected, several at a time, and the top
+// layer of each one is kept, so the images of a run share what was already looked up.
+type layerIndex struct {
+	// mu lets the images of a batch inspected at the same time share the index
+	mu sync.Mutex
+	// topLayers holds the top layer of every image inspected so far, by image ID
+	topLayers map[string]string
+	// failed holds the images that could not be inspected, which are warned about once
+	failed map[string]bool
+}
+
+// INSPECT_CONCURRENCY bounds the images inspected at the same time while looking for FROM images
+const INSPECT_CONCURRENCY = 8
+
+// inspectTopLayers inspects the images whose top layer is not known yet, up to INSPECT_CONCURRENCY
+// at a time, and records their top layers. An image without layers has an empty top layer. Images
+// that cannot be inspected are warned about once and left out of the FROM detection. The progress
+// counts the images from first, out of total.
+func (index *layerIndex) inspectTopLayers(ctx context.Context, cli imageBackend, imgs []image.Summary, first int, total int) (err error) {
+	var pending []image.Summary
+	for _, img := range imgs {
+		if _, ok := index.topLayers[img.ID]; !ok && !index.failed[img.ID] {
+			pending = append(pending, img)
+		}
+	}
+	layers := make([]string, len(pending))
+	errs := make([]error, len(pending))
+	var inspected atomic.Int64
+	inspected.Store(int64(first + len(imgs) - len(pending)))
+	slots := make(chan struct{}, INSPECT_CONCURRENCY)
+	var wg sync.WaitGroup
+	for i, img := range pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-slots }()
+			inspect, _, err := cli.ImageInspectWithRaw(ctx, img.ID)
+			if err != nil {
+				errs[i] = err
+			} else if imageLayers := inspect.RootFS.Layers; len(imageLayers) > 0 {
+				layers[i] = imageLayers[len(imageLayers)-1]
+			}
+			PROGRESS.update("inspecting image %d/%d", inspected.Add(1), total)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for i, img := range pending {
+		if errs[i] != nil {
+			PROGRESS.pause(func() {
+				fmt.Fprintf(os.Stderr, "warning: unable to inspect the image %s: %s\n", img.RepoTags[0], errs[i])
+			})
+			index.failed[img.ID] = true
+			continue
+		}
+		index.topLayers[img.ID] = layers[i]
+	}
+	return nil
+}
+
+// fromImage returns the other local image whose top layer comes lowest in the layers of myImage,
+// which is the image it was built FROM, and the name to show it as.
+//
+// An image is at least as large as every image it is built on, so when the daemon reports sizes the
+// candidates are inspected from the smallest up and the search stops at the first one found, after
+// the others of the same size. Without sizes every tagged image is inspected.
+func (index *layerIndex) fromImage(ctx context.Context, cli imageBackend, imageList []image.Summary, myImage image.Summary, layers []string, deterministic bool) (fromImage string, base image.Summary, err error) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	defer PROGRESS.clear()
+	defer STATS.time(PHASE_LAYER_INDEXING)()
+	if index.topLayers == nil {
+		index.topLayers, index.failed = map[string]string{}, map[string]bool{}
+	}
+
+	position := map[string]int{}
+	for i, layer := range layers {
+		if _, ok := position[layer]; !ok {
+			position[layer] = i
 		}
 	}
-	for _, imageEvent := range imageHistory {
-		if fromLastCreatedBy != "" && imageEvent.CreatedBy == fromLastCreatedBy {
+	var candidates []image.Summary
+	for _, img := range imageList {
+		if img.ID == myImage.ID || !slices.ContainsFunc(img.RepoTags, func(tag string) bool { return tag != "<none>:<none>" }) {
+			continue
+		}
+		if myImage.Size > 0 && (img.Size <= 0 || img.Size > myImage.Size) {
+			continue
+		}
+		candidates = append(candidates, img)
+	}
+	if myImage.Size > 0 {
+		slices.SortStableFunc(candidates, func(a, b image.Summary) int { return cmp.Compare(a.Size, b.Size) })
+	}
+
+	// Without sizes every candidate is needed, otherwise they are inspected a few at a time until
+	// the search stops
+	window := len(candidates)
+	if myImage.Size > 0 {
+		window = INSPECT_CONCURRENCY
+	}
+	best, foundSize := len(layers), int64(-1)
+	for n, img := range candidates {
+		if foundSize >= 0 && myImage.Size > 0 && img.Size > foundSize {
 			break
 		}
-		sanitizedCommand := standardizeSpaces(getStep(imageEvent.CreatedBy))
-		sanitizedCommand = strings.Replace(sanitizedCommand, "/bin/sh -c ", "", -1)
-		sanitizedCommand = strings.Replace(sanitizedCommand, "&&", "\n        &&", -1)
-		dockerCommands = append(dockerCommands, sanitizedCommand)
+		if n%window == 0 {
+			err = index.inspectTopLayers(ctx, cli, candidates[n:min(n+window, len(candidates))], n, len(candidates))
+			if err != nil {
+				return "", base, err
+			}
+		}
+		layer, ok := index.topLayers[img.ID]
+		i, inLayers := position[layer]
+		if !ok || !inLayers || i > best {
+			continue
+		}
+		// Old daemons list untagged images as <none>:<none>
+		tags := slices.DeleteFunc(slices.Clone(img.RepoTags), func(tag string) bool { return tag == "<none>:<none>" })
+		repoTag := tags[0]
+		if deterministic {
+			// The daemon's list and tag order vary between hosts, so always pick the lowest tag
+			repoTag = slices.Min(tags)
+			if i == best && repoTag >= fromImage {
+				continue
+			}
+		} else if i == best {
+			continue
+		}
+		best, fromImage, base, foundSize = i, repoTag, img, img.Size
 	}
-	return dockerCommands
+	return fromImage, base, nil
+}
+
+func extractDockerfile(ctx context.Context, cli imageBackend, imageList []image.Summary, layers *layerIndex, myImage image.Summary, repoTag string, opts *Options, config *Config) (result extraction, err error) {
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, myImage.ID)
+	if err != nil {
+		return result, fmt.Errorf("unable to inspect the image %s: %w", myImage.ID, err)
+	}
+
+	// Get the FROM image
+	fromImage, base, err := layers.fromImage(ctx, cli, imageList, myImage, inspect.RootFS.Layers, opts.Deterministic)
+	if err != nil {
+		return result, err
+	}
+
+	// Gather the evidence the FROM line rests on
+	var evidence baseEvidence
+	if fromImage != "" {
+		baseInspect, _, err := cli.ImageInspectWithRaw(ctx, base.ID)
+		if err != nil {
+			return result, fmt.Errorf("unable to inspect the image %s: %w", base.ID, err)
+		}
+		evidence = baseEvidence{
+			Source:        BASE_SOURCE_LAYERS,
+			MatchedLayers: matchedBaseLayers(inspect.RootFS.Layers, baseInspect.RootFS.Layers),
+			BaseLayers:    len(baseInspect.RootFS.Layers),
+			BaseDigest:    repositoryDigest(base, fromImage),
+		}
+	}
+
+	// Foreign layers, schema 1 manifests and manifest annotations are only known to the sources
+	// that read manifests
+	var manifest manifestDetails
+	if reader, ok := cli.(manifestReader); ok {
+		manifest, err = reader.ManifestDetails(ctx, myImage.ID)
+		if err != nil {
+			return result, fmt.Errorf("unable to read the layers of the image %s: %w", myImage.ID, err)
+		}
+	}
+
+	// The steps of the base image are left out of the history when it is found locally
+	localBase := fromImage
+	// A base image the image names itself is trusted over the layers
+	var labels map[string]string
+	if inspect.Config != nil {
+		labels = inspect.Config.Labels
+	}
+	if declared, ok := declaredBaseImage(labels, manifest); ok {
+		fromImage = declared.name
+		evidence = baseEvidence{Source: declared.source, BaseDigest: declared.digest}
+		// Its layers can only be compared when the declared base is available locally
+		declaredBase := findDeclaredBase(imageList, declared)
+		if declaredBase != "" {
+			baseInspect, _, err := cli.ImageInspectWithRaw(ctx, declaredBase)
+			if err != nil {
+				return result, fmt.Errorf("unable to inspect the image %s: %w", declaredBase, err)
+			}
+			evidence.MatchedLayers = matchedBaseLayers(inspect.RootFS.Layers, baseInspect.RootFS.Layers)
+			evidence.BaseLayers = len(baseInspect.RootFS.Layers)
+		}
+		if localBase == "" {
+			localBase = declaredBase
+		}
+	}
+	var baseStep string
+	if localBase != "" {
+		baseStep, err = newestStep(ctx, cli, localBase)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	// A base image that is not available locally may be found in the registries
+	if fromImage == "" && opts.LookupBases {
+		platform := v1.Platform{OS: inspect.Os, Architecture: inspect.Architecture, Variant: inspect.Variant}
+		found, err := REGISTRY_LOOKUP.lookup(ctx, opts, config, inspect.RootFS.Layers, platform)
+		if err != nil {
+			return result, err
+		}
+		if found != nil {
+			fromImage = found.name
+			baseStep = found.lastStep
+			evidence = baseEvidence{
+				Source:            BASE_SOURCE_LOOKUP,
+				MatchedLayers:     len(found.layers),
+				BaseLayers:        len(found.layers),
+				BaseDigest:        found.digest,
+				RegistryConfirmed: true,
+			}
+		} else {
+			result.BaseLookupMissed = true
+		}
+	}
+
+	// The common base images are identified by the steps that build them, also offline
+	if fromImage == "" {
+		imageHistory, err := cli.ImageHistory(ctx, myImage.ID)
+		if err != nil {
+			return result, fmt.Errorf("unable to fetch the history of the image %s: %w", myImage.ID, err)
+		}
+		if baseImage, lastStep, ok := fingerprintBase(imageHistory, inspect.Config); ok {
+			fromImage = baseImage
+			baseStep = lastStep
+			evidence.Source = BASE_SOURCE_FINGERPRINT
+		}
+	}
+
+	// Parse image history
+	done := STATS.time(PHASE_HISTORY_PARSE)
+	dockerCommands, builders, contents, historyBase, err := parseImageHistory(ctx, cli, myImage, inspect.Config, baseStep)
+	done()
+	if err != nil {
+		return result, err
+	}
+	if fromImage == "" && historyBase != "" {
+		fromImage = historyBase
+		evidence.Source = BASE_SOURCE_HISTORY
+	}
+	if fromImage == "" && inspect.Parent == "" && startsFromScratch(dockerCommands) {
+		fromImage = SCRATCH
+		evidence.Source = BASE_SOURCE_SCRATCH
+	}
+	confidence := scoreBase(evidence, fromImage)
+
+	// Handle the FROM image
+	if fromImage != "" {
+		from := fromImage
+		if opts.PinDigests && evidence.BaseDigest != "" && !strings.Contains(fromImage, "@") {
+			from = fromImage + "@" + evidence.BaseDigest
+		}
+		dockerCommands = append(dockerCommands, fmt.Sprintf("FROM %s", from))
+	} else {
+		dockerCommands = append(dockerCommands, "FROM <base image not found locally>")
+	}
+
+	builders = append(builders, "")
+
+	// Reverse the list of commands for output
+	slices.Reverse(dockerCommands)
+	slices.Reverse(builders)
+	if opts.GroupLabels {
+		dockerCommands, builders = groupLabels(dockerCommands, builders)
+	}
+	if opts.CollapseWorkdirs {
+		dockerCommands, builders = collapseWorkdirs(dockerCommands, builders)
+	}
+	slices.Reverse(contents)
+
+	result = extraction{
+		Image:           repoTag,
+		ImageID:         myImage.ID,
+		RepoTags:        inspect.RepoTags,
+		RepoDigests:     inspect.RepoDigests,
+		Created:         inspect.Created,
+		OS:              inspect.Os,
+		Architecture:    inspect.Architecture,
+		Variant:         inspect.Variant,
+		ForeignLayers:   manifest.ForeignLayers,
+		ManifestSchema1: manifest.Schema1,
+		BaseImage:       fromImage,
+		BaseConfidence:  &confidence,
+		Config:          newImageConfig(inspect.Config),
+		Instructions:    dockerCommands,
+		Builders:        builders,
+		Contents:        copiedContents(dockerCommands, contents),
+	}
+	return result, nil
 }
 
 func main() {
 	var err error
-	var repoTag string
 
 	opts := Options{}
 
@@ -229,81 +1233,399 @@ func main() {
 	}
 
 	// Process the options
-	imageId, socketName, outputFile, err := processOptions(opts)
+	err = processOptions(&opts)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	// Get the image name
-	if strings.Contains(imageId, ":") {
-		repoTag = imageId
-	} else {
-		repoTag = fmt.Sprintf("%s:latest", imageId)
+	err = PROFILING.start(&opts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
+	defer PROFILING.stop()
 
-	// Create the client
-	cli, err := client.NewClientWithOpts(
-		client.WithHost(fmt.Sprintf("unix://%s", socketName)),
-		client.WithVersion(DOCKER_API_VERSION),
-	)
+	config, err := loadConfig(opts.ConfigFile)
 	if err != nil {
-		fmt.Printf("unable to create the docker client: %s\n", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	// Fetch the image list
-	imageList, err := cli.ImageList(context.Background(), image.ListOptions{})
+	p, base, err := loadPolicyOptions(&opts)
 	if err != nil {
-		fmt.Printf("unable to generate the list of images: %s\n", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	// Find the image in the list of imageList
-	myImage, err := findImageFromImageList(imageList, imageId, repoTag)
+	ctx, cancel := newContext(&opts)
+	defer cancel()
+
+	// Create the client
+	cli, err := newBackend(&opts, config)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	// Get layers with images
-	layersWithImages := getLayersWithImages(cli, imageList)
+	// Fetch the image list
+	done := STATS.time(PHASE_IMAGE_LIST)
+	imageList, err := cli.ImageList(ctx, image.ListOptions{})
+	done()
+	if err != nil {
+		exitWithError(ctx, fmt.Errorf("unable to generate the list of images: %w", err))
+	}
 
-	// Get the FROM image
-	fromImage := getFromImage(cli, myImage, layersWithImages)
+	// Select the images to inspect
+	var targets []imageTarget
+	switch {
+	case opts.All:
+		for _, img := range imageList {
+			targets = append(targets, listedTarget(img))
+		}
+		if len(targets) == 0 {
+			fmt.Fprintln(os.Stderr, "warning: there are no images to inspect")
+		}
+	case len(opts.Match) > 0 || len(opts.MatchRe) > 0:
+		targets = matchingTargets(imageList, opts.Match, opts.MatchRe)
+		if len(targets) == 0 {
+			fmt.Println("no image matches --match or --match-re")
+			os.Exit(1)
+		}
+	case len(opts.ImageNames) > 0:
+		for _, imageName := range opts.ImageNames {
+			targets = append(targets, namedTarget(imageName))
+		}
+	default:
+		// An archive holding a single image needs no --image
+		if len(imageList) != 1 {
+			input := opts.Input
+			if input == "-" {
+				input = "The archive read from STDIN"
+			}
+			fmt.Printf("%s holds %d images - use --image to choose one\n", input, len(imageList))
+			os.Exit(1)
+		}
+		targets = []imageTarget{listedTarget(imageList[0])}
+	}
+	if opts.Deterministic {
+		// The daemon lists images in an order that varies between hosts
+		slices.SortStableFunc(targets, func(a, b imageTarget) int {
+			return strings.Compare(a.repoTag, b.repoTag)
+		})
+	}
 
-	// Parse image history
-	dockerCommands := parseImageHistory(cli, myImage, fromImage)
+	var filenameTemplate *template.Template
+	if opts.OutputDir != "" {
+		filenameTemplate, err = parseFilenameTemplate(opts.FilenameTemplate, opts.Format)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
-	// Handle the FROM image
-	if fromImage != "" {
-		dockerCommands = append(dockerCommands, fmt.Sprintf("FROM %s", fromImage))
-	} else {
-		dockerCommands = append(dockerCommands, "FROM <base image not found locally>")
+	// Every file of --output-dir is named before any image is inspected, so clashes stop the run early
+	outputFiles := make([]string, len(targets))
+	written := map[string]string{}
+	for i, target := range targets {
+		outputFiles[i] = opts.OutputFile
+		if opts.OutputDir == "" {
+			continue
+		}
+		outputFiles[i], err = outputPath(opts.OutputDir, filenameTemplate, target.repoTag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if other, ok := written[outputFiles[i]]; ok {
+			fmt.Printf("the images %s and %s would both be written to %s - use a --filename-template that tells them apart\n", other, target.repoTag, outputFiles[i])
+			os.Exit(1)
+		}
+		written[outputFiles[i]] = target.repoTag
 	}
 
-	// Reverse the list of commands for output
-	slices.Reverse(dockerCommands)
+	// Every image shares the connection and the layer index
+	var layers layerIndex
+	var policyFailed bool
+	var summaries []imageSummary
+	var printed, failed int
+	// JSON Lines on STDOUT are a stream, printed as soon as every image is done
+	ordered := opts.Format != "jsonl" || opts.OutputDir != "" || opts.Deterministic
+	inspectConcurrently(ctx, opts.Concurrency, len(targets), ordered, func(i int, out *bufferedOutput) (imageSummary, error) {
+		return inspectImage(ctx, cli, imageList, &layers, targets[i], out.output(outputFiles[i]), &opts, config, p, base)
+	}, func(i int, result inspectResult) {
+		target, summary, err := targets[i], result.summary, result.err
+		// An unchanged image has no output
+		if err == nil && printed > 0 && outputFiles[i] == "" && !summary.Unchanged {
+			fmt.Print(outputSeparator(opts.Format))
+		}
+		result.output.replay()
+		// A single image fails the run, while a batch carries on with the other images unless the
+		// run itself was interrupted or timed out
+		if err != nil && (len(targets) == 1 || ctx.Err() != nil) {
+			exitWithError(ctx, err)
+		} else if err != nil {
+			summary = imageSummary{Image: target.repoTag, Error: err}
+			fmt.Fprintf(os.Stderr, "error: %s: %s\n", target.repoTag, err)
+			if opts.Format == "jsonl" && outputFiles[i] == "" {
+				line, err := renderJsonErrorLine(target.repoTag, summary.Error)
+				if err == nil {
+					fmt.Print(line)
+				}
+			}
+			failed++
+		} else if !summary.Unchanged {
+			printed++
+		} else if opts.Format == "jsonl" && outputFiles[i] == "" {
+			// The stream has a line for every image, including those already reported by the last run
+			line, err := renderJsonUnchangedLine(summary)
+			if err == nil {
+				fmt.Print(line)
+			}
+		}
+		summaries = append(summaries, summary)
+		policyFailed = policyFailed || summary.PolicyFailed
+	})
+	// The summary goes to STDERR, where it cannot end up in the output
+	if len(summaries) > 1 {
+		fmt.Fprintln(os.Stderr)
+		printBatchSummary(os.Stderr, summaries)
+	}
+	if opts.Stats {
+		fmt.Fprintln(os.Stderr)
+		STATS.print(os.Stderr)
+	}
+	PROFILING.stop()
+	if failed > 0 {
+		os.Exit(EXIT_PARTIAL_FAILURE)
+	}
+	if policyFailed {
+		os.Exit(EXIT_POLICY_FAILURE)
+	}
+}
+
+// outputSeparator goes between the outputs of several images printed to STDOUT.
+func outputSeparator(format string) (separator string) {
+	switch format {
+	case "json", "sarif", "jsonl":
+		return ""
+	case "k8s":
+		return "---\n"
+	}
+	return "\n"
+}
+
+// imageTarget is an image to inspect, by the name or ID it is looked up with and the name it is
+// shown as.
+type imageTarget struct {
+	imageId string
+	repoTag string
+}
+
+// namedTarget targets an image given by name, which defaults to the latest tag.
+func namedTarget(imageName string) (target imageTarget) {
+	if strings.Contains(imageName, ":") {
+		return imageTarget{imageId: imageName, repoTag: imageName}
+	}
+	return imageTarget{imageId: imageName, repoTag: fmt.Sprintf("%s:latest", imageName)}
+}
+
+// listedTarget targets an image of the image list, shown as its first tag or else its ID.
+func listedTarget(img image.Summary) (target imageTarget) {
+	imageId := strings.TrimPrefix(img.ID, "sha256:")
+	for _, tag := range img.RepoTags {
+		// Old daemons list untagged images as <none>:<none>
+		if tag != "<none>:<none>" {
+			return imageTarget{imageId: imageId, repoTag: tag}
+		}
+	}
+	return imageTarget{imageId: imageId, repoTag: imageId}
+}
+
+// matchingTargets targets the images with a name matching one of the glob patterns or regular
+// expressions, each shown as its first matching name.
+func matchingTargets(imageList []image.Summary, patterns []string, exprs []string) (targets []imageTarget) {
+	var res []*regexp.Regexp
+	for _, expr := range exprs {
+		res = append(res, regexp.MustCompile(expr))
+	}
+	for _, img := range imageList {
+		for _, tag := range img.RepoTags {
+			if tag == "<none>:<none>" {
+				continue
+			}
+			matches := slices.ContainsFunc(patterns, func(pattern string) bool { return matchImagePattern(pattern, tag) }) ||
+				slices.ContainsFunc(res, func(re *regexp.Regexp) bool { return matchImageRegexp(re, tag) })
+			if matches {
+				targets = append(targets, imageTarget{imageId: strings.TrimPrefix(img.ID, "sha256:"), repoTag: tag})
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// inspectImage reconstructs the Dockerfile of one image, evaluates the policy and writes the output.
+// It returns a summary of the image, including whether the policy failed, which is reported once all
+// images are done.
+func inspectImage(ctx context.Context, cli imageBackend, imageList []image.Summary, layers *layerIndex, target imageTarget, out imageOutput, opts *Options, config *Config, p *policy, base *baseline) (summary imageSummary, err error) {
+	repoTag := target.repoTag
+	start := time.Now()
+
+	// Find the image in the list of imageList
+	myImage, err := findImageFromImageList(imageList, target.imageId, repoTag)
+	if err != nil {
+		return summary, err
+	}
+
+	// An image of a batch that has not changed was already reported by the last run
+	var record runRecord
+	runKey := runRecordKey(repoTag, out.file, opts)
+	if opts.incremental {
+		inspect, _, err := cli.ImageInspectWithRaw(ctx, myImage.ID)
+		if err != nil {
+			return summary, fmt.Errorf("unable to inspect the image %s: %w", myImage.ID, err)
+		}
+		record.ConfigDigest, err = imageConfigDigest(inspect)
+		if err != nil {
+			return summary, err
+		}
+		// An output file that was removed since is written again
+		last, ok := readRunRecord(runKey)
+		if ok && last.ImageID == myImage.ID && last.ConfigDigest == record.ConfigDigest && (out.file == "" || fileExists(out.file)) {
+			return imageSummary{
+				Image:        repoTag,
+				BaseImage:    last.BaseImage,
+				Instructions: last.Instructions,
+				Warnings:     last.Warnings,
+				PolicyFailed: last.PolicyFailed,
+				Unchanged:    true,
+			}, nil
+		}
+	}
+
+	// Serve a previously rendered result for the same image and inputs
+	var result extraction
+	var cached bool
+	cacheKey := resultCacheKey(myImage.ID, imageList, opts, config)
+	if !opts.NoCache {
+		var entry cachedResult
+		entry, cached = readCachedResult(cacheKey)
+		result = entry.Extraction
+	}
+
+	if !cached {
+		result, err = extractDockerfile(ctx, cli, imageList, layers, myImage, repoTag, opts, config)
+		if err != nil {
+			return summary, err
+		}
+		err = writeCachedResult(cacheKey, cachedResult{
+			Created:    time.Now(),
+			Source:     backendSource(cli),
+			Extraction: result,
+		})
+		if err != nil {
+			fmt.Fprintf(out.stderr, "warning: unable to cache the result: %s\n", err)
+		}
+	}
+
+	// Evaluate the policy
+	document := newJsonDocument(result, opts.Deterministic)
+	document.annotateBuilders = opts.AnnotateBuilders
+	if warning := platformWarning(result, opts); warning != "" {
+		document.Warnings = append(document.Warnings, warning)
+	}
+	if note := hostPlatformNote(result, opts); note != "" {
+		fmt.Fprintf(out.stderr, "warning: %s\n", note)
+	}
+	if result.ForeignLayers > 0 {
+		noun := "layers"
+		if result.ForeignLayers == 1 {
+			noun = "layer"
+		}
+		document.Warnings = append(document.Warnings, fmt.Sprintf("the image has %d foreign %s, such as Windows base layers, which are not distributed with it - the steps that created them are taken from its history only", result.ForeignLayers, noun))
+	}
+	if opts.PinDigests && result.BaseImage != "" && result.BaseImage != SCRATCH && !strings.Contains(result.BaseImage, "@") && (result.BaseConfidence == nil || result.BaseConfidence.Evidence.BaseDigest == "") {
+		document.Warnings = append(document.Warnings, fmt.Sprintf("the base image %s has no repository digest to pin it to - pull it from a registry first", result.BaseImage))
+	}
+	if result.BaseLookupMissed {
+		document.Warnings = append(document.Warnings, "no --lookup-bases candidate starts the image - only the listed tags are compared, so a base image on another release needs its tag in the base_candidates of the config file")
+	}
+	if result.ManifestSchema1 {
+		document.Warnings = append(document.Warnings, "the image has a legacy Docker schema 1 manifest, which records less about the build than an image config - the Dockerfile may be less accurate")
+	}
+	for _, warning := range document.Warnings {
+		fmt.Fprintf(out.stderr, "warning: %s\n", warning)
+	}
+	var suppressed int
+	if p != nil {
+		document.Findings = p.evaluate(result)
+	}
+	if opts.WriteBaseline != "" {
+		base, err = writeBaseline(opts.WriteBaseline, base, document.Findings)
+		if err != nil {
+			return summary, err
+		}
+		fmt.Fprintf(out.stderr, "Baseline with %d findings written to %s.\n", len(base.Findings), opts.WriteBaseline)
+	}
+	document.Findings, suppressed = base.apply(document.Findings)
+
+	// Write the evidence bundle
+	if opts.Bundle != "" {
+		err = writeBundle(opts.Bundle, document, config)
+		if err != nil {
+			return summary, err
+		}
+		fmt.Fprintf(out.stdout, "Bundle successfully written to %s.\n", opts.Bundle)
+	}
 
 	// Print the output to either file or STDOUT
-	if outputFile != "" {
-		f, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE, 0644)
+	if out.file != "" || opts.Bundle == "" {
+		done := STATS.time(PHASE_RENDER)
+		output, err := renderOutput(opts.Format, document, config)
+		done()
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return summary, err
 		}
-		defer f.Close()
-		for _, dockerCommand := range dockerCommands {
-			_, err = f.WriteString(dockerCommand)
+		if opts.Edit {
+			var warnings []string
+			output, warnings, err = editDockerfile(ctx, output)
 			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+				return summary, err
+			}
+			for _, warning := range warnings {
+				fmt.Fprintf(out.stderr, "warning: %s\n", warning)
 			}
 		}
-		fmt.Printf("File successfully written to %s.\n", outputFile)
-	} else {
-		for _, dockerCommand := range dockerCommands {
-			fmt.Println(dockerCommand)
+		err = writeOutput(out.stdout, out.file, output, opts.Update)
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	// Report the policy result last so CI logs end with it
+	summary = imageSummary{
+		Image:        result.Image,
+		BaseImage:    result.BaseImage,
+		Instructions: len(result.Instructions),
+		Warnings:     len(document.Warnings),
+		PolicyFailed: p != nil && printPolicyReport(out.stderr, p, document.Findings, suppressed),
+	}
+	summary.Duration = time.Since(start)
+
+	if opts.incremental {
+		record.Created = time.Now()
+		record.Image = repoTag
+		record.ImageID = myImage.ID
+		record.Source = backendSource(cli)
+		record.BaseImage = summary.BaseImage
+		record.Instructions = summary.Instructions
+		record.Warnings = summary.Warnings
+		record.PolicyFailed = summary.PolicyFailed
+		err = writeRunRecord(runKey, record)
+		if err != nil {
+			fmt.Fprintf(out.stderr, "warning: unable to record the run: %s\n", err)
 		}
 	}
+	return summary, nil
 }
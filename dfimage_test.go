@@ -0,0 +1,354 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+func TestCutBuildArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		command   string
+		shell     []string
+		buildArgs []string
+		rest      string
+	}{
+		{
+			name:    "no build arguments",
+			command: "/bin/sh -c make",
+			rest:    "/bin/sh -c make",
+		},
+		{
+			name:      "one build argument",
+			command:   "|1 VERSION=1.2 /bin/sh -c make",
+			buildArgs: []string{"VERSION=1.2"},
+			rest:      "/bin/sh -c make",
+		},
+		{
+			name:      "several build arguments",
+			command:   "|2 VERSION=1.2 FOO=bar /bin/sh -c make",
+			buildArgs: []string{"VERSION=1.2", "FOO=bar"},
+			rest:      "/bin/sh -c make",
+		},
+		{
+			name:      "= in a value",
+			command:   "|1 LDFLAGS=-X=main.version=1.2 /bin/sh -c go build",
+			buildArgs: []string{"LDFLAGS=-X=main.version=1.2"},
+			rest:      "/bin/sh -c go build",
+		},
+		{
+			name:      "empty value",
+			command:   "|2 PROXY= VERSION=1.2 /bin/sh -c make",
+			buildArgs: []string{"PROXY=", "VERSION=1.2"},
+			rest:      "/bin/sh -c make",
+		},
+		{
+			name:      "quotes are part of the value",
+			command:   `|1 NAME="app" /bin/sh -c echo "$NAME"`,
+			buildArgs: []string{`NAME="app"`},
+			rest:      `/bin/sh -c echo "$NAME"`,
+		},
+		{
+			name:      "quoted value with spaces",
+			command:   `|1 MSG="hello world" /bin/sh -c echo $MSG`,
+			buildArgs: []string{`MSG="hello world"`},
+			rest:      "/bin/sh -c echo $MSG",
+		},
+		{
+			name:      "unquoted values with spaces",
+			command:   "|2 MSG=hello world FLAGS=-O2 -g /bin/sh -c make",
+			buildArgs: []string{"MSG=hello world", "FLAGS=-O2 -g"},
+			rest:      "/bin/sh -c make",
+		},
+		{
+			name:      "empty last value",
+			command:   "|1 PROXY= /bin/sh -c make",
+			buildArgs: []string{"PROXY="},
+			rest:      "/bin/sh -c make",
+		},
+		{
+			name:      "custom shell",
+			command:   "|1 VERSION=1.2 /bin/bash -o pipefail -c make | tee log",
+			shell:     []string{"/bin/bash", "-o", "pipefail", "-c"},
+			buildArgs: []string{"VERSION=1.2"},
+			rest:      "/bin/bash -o pipefail -c make | tee log",
+		},
+		{
+			name:      "Windows shell",
+			command:   `|1 VERSION=1.2 cmd /S /C build.cmd`,
+			buildArgs: []string{"VERSION=1.2"},
+			rest:      "cmd /S /C build.cmd",
+		},
+		{
+			name:    "a value that looks like another argument is ambiguous",
+			command: "|1 OPTS=-v X=1 /bin/sh -c make",
+			rest:    "|1 OPTS=-v X=1 /bin/sh -c make",
+		},
+		{
+			name:      "flags of the step stay in front",
+			command:   "--mount=type=secret,id=npmrc |1 VERSION=1.2 /bin/sh -c npm ci",
+			buildArgs: []string{"VERSION=1.2"},
+			rest:      "--mount=type=secret,id=npmrc /bin/sh -c npm ci",
+		},
+		{
+			name:    "fewer arguments than the count",
+			command: "|2 VERSION=1.2 /bin/sh -c make",
+			rest:    "|2 VERSION=1.2 /bin/sh -c make",
+		},
+		{
+			name:      "without a known shell a value ends at the next space",
+			command:   "|1 VERSION=1.2 make all",
+			buildArgs: []string{"VERSION=1.2"},
+			rest:      "make all",
+		},
+		{
+			name:      "BuildKit command line",
+			command:   "|1 TARGETARCH=amd64 /bin/sh -c GOARCH=$TARGETARCH go build ./...",
+			buildArgs: []string{"TARGETARCH=amd64"},
+			rest:      "/bin/sh -c GOARCH=$TARGETARCH go build ./...",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buildArgs, rest := cutBuildArgs(test.command, test.shell)
+			if !slices.Equal(buildArgs, test.buildArgs) || rest != test.rest {
+				t.Errorf("cutBuildArgs(%q) = %q, %q, want %q, %q", test.command, buildArgs, rest, test.buildArgs, test.rest)
+			}
+		})
+	}
+}
+
+// TestRunCommand feeds the created_by of RUN steps, as the builders record them, through the steps
+// parseImageHistory takes.
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		createdBy string
+		shell     []string
+		want      string
+	}{
+		{
+			name:      "classic builder",
+			createdBy: "/bin/sh -c apk add --no-cache curl",
+			want:      "RUN apk add --no-cache curl",
+		},
+		{
+			name:      "classic builder continued at &&",
+			createdBy: "/bin/sh -c apt-get update && apt-get install -y curl && rm -rf /var/lib/apt/lists/*",
+			want:      "RUN apt-get update \\\n        && apt-get install -y curl \\\n        && rm -rf /var/lib/apt/lists/*",
+		},
+		{
+			name:      "classic builder with build arguments",
+			createdBy: "|2 VERSION=1.2 TARGETARCH=amd64 /bin/sh -c make VERSION=$VERSION",
+			want:      "RUN make VERSION=$VERSION",
+		},
+		{
+			name:      "BuildKit",
+			createdBy: "RUN /bin/sh -c go build -o /app ./... # buildkit",
+			want:      "RUN go build -o /app ./...",
+		},
+		{
+			name:      "BuildKit with a cache mount",
+			createdBy: "RUN --mount=type=cache,target=/root/.cache/pip /bin/sh -c pip install -r requirements.txt # buildkit",
+			want:      "RUN --mount=type=cache,target=/root/.cache/pip pip install -r requirements.txt",
+		},
+		{
+			name:      "BuildKit heredoc script",
+			createdBy: "RUN /bin/sh -c set -e\napk add --no-cache curl\nmake\n # buildkit",
+			want:      "RUN <<EOF\nset -e\napk add --no-cache curl\nmake\nEOF",
+		},
+		{
+			name:      "BuildKit heredoc script with an EOF line",
+			createdBy: "RUN /bin/sh -c cat > /tmp/x <<'EOF2'\nEOF\nEOF2\necho done # buildkit",
+			want:      "RUN <<EOF1\ncat > /tmp/x <<'EOF2'\nEOF\nEOF2\necho done\nEOF1",
+		},
+		{
+			name:      "BuildKit heredoc read by a command",
+			createdBy: "RUN /bin/sh -c cat <<EOF > /etc/motd\nWelcome\nEOF # buildkit",
+			want:      "RUN cat <<EOF > /etc/motd\nWelcome\nEOF",
+		},
+		{
+			name:      "BuildKit heredoc with flags and build arguments",
+			createdBy: "RUN --network=none |1 VERSION=1.2 /bin/sh -c set -e\nmake # buildkit",
+			want:      "RUN --network=none <<EOF\nset -e\nmake\nEOF",
+		},
+		{
+			name:      "custom shell",
+			createdBy: "RUN /bin/bash -o pipefail -c curl -fsSL https://example.com/install.sh | bash # buildkit",
+			shell:     []string{"/bin/bash", "-o", "pipefail", "-c"},
+			want:      "RUN curl -fsSL https://example.com/install.sh | bash",
+		},
+		{
+			name:      "Windows",
+			createdBy: "cmd /S /C powershell -Command Install-WindowsFeature Web-Server",
+			want:      "RUN powershell -Command Install-WindowsFeature Web-Server",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command, ok := strings.CutPrefix(getStep(test.createdBy), "RUN ")
+			if !ok {
+				t.Fatalf("getStep(%q) is not a RUN step", test.createdBy)
+			}
+			_, command = cutBuildArgs(command, test.shell)
+			if got := "RUN " + runCommand(command, test.shell); got != test.want {
+				t.Errorf("RUN step of %q = %q, want %q", test.createdBy, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckOffline(t *testing.T) {
+	tests := []struct {
+		name    string
+		offline bool
+		host    string
+		wantErr bool
+	}{
+		{
+			name:    "a unix socket",
+			offline: true,
+			host:    "unix:///var/run/docker.sock",
+		},
+		{
+			name:    "a named pipe",
+			offline: true,
+			host:    "npipe:////./pipe/docker_engine",
+		},
+		{
+			name:    "a socket path",
+			offline: true,
+			host:    "/run/containerd/containerd.sock",
+		},
+		{
+			name:    "a TCP endpoint",
+			offline: true,
+			host:    "tcp://10.0.0.5:2376",
+			wantErr: true,
+		},
+		{
+			name:    "an SSH endpoint",
+			offline: true,
+			host:    "ssh://user@build-host",
+			wantErr: true,
+		},
+		{
+			name: "a TCP endpoint without --offline",
+			host: "tcp://10.0.0.5:2376",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkOffline(test.offline, "CRI endpoint", test.host)
+			if (err != nil) != test.wantErr {
+				t.Errorf("checkOffline() error = %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatchImagePattern(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		imageName string
+		matches   bool
+	}{
+		{pattern: "myorg/*:prod-*", imageName: "myorg/api:prod-1", matches: true},
+		{pattern: "myorg/*:prod-*", imageName: "myorg/team/api:prod-2", matches: true},
+		{pattern: "myorg/*:prod-*", imageName: "myorg/api:dev-1"},
+		{pattern: "myorg/*:prod-*", imageName: "otherorg/myorg/api:prod-1"},
+		{pattern: "alpine:3.*", imageName: "alpine:3.20", matches: true},
+		{pattern: "alpine:3.*", imageName: "alpine:latest"},
+		{pattern: "alpine", imageName: "alpine:3.20"},
+		{pattern: "app:1.0", imageName: "app:100"},
+		{pattern: "app:1.?", imageName: "app:1.0", matches: true},
+		{pattern: "app:1.?", imageName: "app:1.10"},
+		{pattern: "docker.io/library/alpine:*", imageName: "alpine:3.20", matches: true},
+		{pattern: "alpine:*", imageName: "docker.io/library/alpine:3.20", matches: true},
+		{pattern: "registry.example.com/*", imageName: "registry.example.com/platform/python:3.12", matches: true},
+		{pattern: "registry.example.com/*", imageName: "python:3.12"},
+		{pattern: "*.example.com/*", imageName: "registry.example.com/app:1.0", matches: true},
+		{pattern: "*:latest", imageName: "ghcr.io/org/app:latest", matches: true},
+		{pattern: "*@sha256:*", imageName: "app@sha256:2b0079146a74d8c1d0c1c4d5e1b0b5b5b2b0079146a74d8c1d0c1c4d5e1b0b5b", matches: true},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.imageName, func(t *testing.T) {
+			if got := matchImagePattern(test.pattern, test.imageName); got != test.matches {
+				t.Errorf("matchImagePattern() = %t, want %t", got, test.matches)
+			}
+		})
+	}
+}
+
+func TestMatchingTargets(t *testing.T) {
+	imageList := []image.Summary{
+		{ID: "sha256:aaa", RepoTags: []string{"myorg/api:dev-7", "myorg/api:prod-7"}},
+		{ID: "sha256:bbb", RepoTags: []string{"registry.example.com/myorg/web:prod-3"}},
+		{ID: "sha256:ccc", RepoTags: []string{"<none>:<none>"}},
+		{ID: "sha256:ddd", RepoTags: []string{"alpine:3.20"}},
+	}
+	tests := []struct {
+		name     string
+		patterns []string
+		exprs    []string
+		targets  []imageTarget
+	}{
+		{
+			name:     "a tag pattern",
+			patterns: []string{"myorg/*:prod-*"},
+			targets:  []imageTarget{{imageId: "aaa", repoTag: "myorg/api:prod-7"}},
+		},
+		{
+			name:     "a registry prefix",
+			patterns: []string{"registry.example.com/*"},
+			targets:  []imageTarget{{imageId: "bbb", repoTag: "registry.example.com/myorg/web:prod-3"}},
+		},
+		{
+			name:     "an image matched by several of its tags",
+			patterns: []string{"myorg/api:*"},
+			targets:  []imageTarget{{imageId: "aaa", repoTag: "myorg/api:dev-7"}},
+		},
+		{
+			name:     "several patterns",
+			patterns: []string{"*:prod-*", "alpine:*"},
+			targets: []imageTarget{
+				{imageId: "aaa", repoTag: "myorg/api:prod-7"},
+				{imageId: "bbb", repoTag: "registry.example.com/myorg/web:prod-3"},
+				{imageId: "ddd", repoTag: "alpine:3.20"},
+			},
+		},
+		{
+			name:     "a pattern and a regular expression",
+			patterns: []string{"alpine:*"},
+			exprs:    []string{`web:prod-\d+$`},
+			targets: []imageTarget{
+				{imageId: "bbb", repoTag: "registry.example.com/myorg/web:prod-3"},
+				{imageId: "ddd", repoTag: "alpine:3.20"},
+			},
+		},
+		{
+			name:     "untagged images are never matched",
+			patterns: []string{"*"},
+			targets: []imageTarget{
+				{imageId: "aaa", repoTag: "myorg/api:dev-7"},
+				{imageId: "bbb", repoTag: "registry.example.com/myorg/web:prod-3"},
+				{imageId: "ddd", repoTag: "alpine:3.20"},
+			},
+		},
+		{
+			name:     "no match",
+			patterns: []string{"debian:*"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			targets := matchingTargets(imageList, test.patterns, test.exprs)
+			if !slices.Equal(targets, test.targets) {
+				t.Errorf("matchingTargets() = %+v, want %+v", targets, test.targets)
+			}
+		})
+	}
+}
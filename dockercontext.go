@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+type dockerEndpoint struct {
+	Host          string
+	CACert        string
+	Cert          string
+	Key           string
+	SkipTLSVerify bool
+}
+
+type dockerContextMeta struct {
+	Name      string
+	Endpoints map[string]struct {
+		Host          string
+		SkipTLSVerify bool
+	}
+}
+
+func dockerConfigDir() (dir string, err error) {
+	if dir = os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	user, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(user.HomeDir, ".docker"), nil
+}
+
+// currentDockerContext returns the context the docker CLI would use when none is given on the
+// command line: DOCKER_CONTEXT, then currentContext from config.json, then "default".
+func currentDockerContext() (name string) {
+	if name = os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name
+	}
+	configDir, err := dockerConfigDir()
+	if err != nil {
+		return "default"
+	}
+	contents, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return "default"
+	}
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if json.Unmarshal(contents, &config) != nil || config.CurrentContext == "" {
+		return "default"
+	}
+	return config.CurrentContext
+}
+
+// resolveDockerContext reads the docker endpoint of a context from the docker CLI's context store,
+// which keeps each context under a directory named after the SHA-256 of its name.
+func resolveDockerContext(name string) (endpoint dockerEndpoint, err error) {
+	configDir, err := dockerConfigDir()
+	if err != nil {
+		return endpoint, err
+	}
+	digest := sha256.Sum256([]byte(name))
+	contextId := hex.EncodeToString(digest[:])
+
+	contents, err := os.ReadFile(filepath.Join(configDir, "contexts", "meta", contextId, "meta.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return endpoint, fmt.Errorf("the docker context \"%s\" does not exist - see \"docker context ls\"", name)
+	} else if err != nil {
+		return endpoint, fmt.Errorf("unable to read the docker context \"%s\": %w", name, err)
+	}
+	var meta dockerContextMeta
+	err = json.Unmarshal(contents, &meta)
+	if err != nil {
+		return endpoint, fmt.Errorf("unable to parse the docker context \"%s\": %w", name, err)
+	}
+	dockerEndpoint, ok := meta.Endpoints["docker"]
+	if !ok || dockerEndpoint.Host == "" {
+		return endpoint, fmt.Errorf("the docker context \"%s\" has no docker endpoint", name)
+	}
+	endpoint.Host = dockerEndpoint.Host
+	endpoint.SkipTLSVerify = dockerEndpoint.SkipTLSVerify
+
+	tlsDir := filepath.Join(configDir, "contexts", "tls", contextId, "docker")
+	for path, file := range map[*string]string{&endpoint.CACert: "ca.pem", &endpoint.Cert: "cert.pem", &endpoint.Key: "key.pem"} {
+		if fileExists(filepath.Join(tlsDir, file)) {
+			*path = filepath.Join(tlsDir, file)
+		}
+	}
+	return endpoint, nil
+}
+
+func (endpoint dockerEndpoint) clientOpts() (clientOpts []client.Opt, err error) {
+	if endpoint.CACert != "" || endpoint.Cert != "" || endpoint.SkipTLSVerify {
+		config, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             endpoint.CACert,
+			CertFile:           endpoint.Cert,
+			KeyFile:            endpoint.Key,
+			InsecureSkipVerify: endpoint.SkipTLSVerify,
+			ExclusiveRootPools: true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the TLS material of the docker context: %w", err)
+		}
+		// WithHost configures this transport for the endpoint's protocol, keeping the TLS settings
+		clientOpts = append(clientOpts, client.WithHTTPClient(&http.Client{Transport: &http.Transport{TLSClientConfig: config}}))
+	}
+	return append(clientOpts, client.WithHost(endpoint.Host)), nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/linter"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+// editorCommand returns the editor to open files with, like git: $VISUAL, then $EDITOR, then vi
+// or notepad.
+func editorCommand() (command []string) {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if command = strings.Fields(os.Getenv(name)); len(command) > 0 {
+			return command
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// lintDockerfile parses a Dockerfile and runs the BuildKit linter over it. A Dockerfile that cannot
+// be parsed is an error, while the lint and parser warnings are only reported.
+func lintDockerfile(dockerfile string) (warnings []string, err error) {
+	result, err := parser.Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range result.Warnings {
+		warnings = append(warnings, fmt.Sprintf("line %d: %s", warning.Location.Start.Line, warning.Short))
+	}
+	lint := linter.New(&linter.Config{
+		Warn: func(rulename, description, url, fmtmsg string, location []parser.Range) {
+			line := 0
+			if len(location) > 0 {
+				line = location[0].Start.Line
+			}
+			warnings = append(warnings, fmt.Sprintf("line %d: %s (%s)", line, fmtmsg, rulename))
+		},
+	})
+	_, _, err = instructions.Parse(result.AST, lint)
+	if err != nil {
+		return warnings, err
+	}
+	return warnings, nil
+}
+
+// editDockerfile opens the Dockerfile in the editor and checks the result, returning the lint
+// warnings about it. As long as the edited Dockerfile cannot be parsed the editor can be opened
+// again, until it is fixed or given up on. The editor and the prompt use the terminal directly.
+func editDockerfile(ctx context.Context, dockerfile string) (edited string, warnings []string, err error) {
+	dir, err := os.MkdirTemp("", "dfimage-edit-")
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create a file to edit: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	// Naming the file Dockerfile lets editors pick the right syntax highlighting
+	path := filepath.Join(dir, "Dockerfile")
+	err = os.WriteFile(path, []byte(dockerfile), 0600)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create a file to edit: %w", err)
+	}
+
+	command := editorCommand()
+	for {
+		editor := exec.Command(command[0], append(command[1:], path)...)
+		// The editor draws on STDERR so that STDOUT can still be redirected to the final file
+		editor.Stdin, editor.Stdout, editor.Stderr = os.Stdin, os.Stderr, os.Stderr
+		err = editor.Run()
+		if err != nil {
+			return "", nil, fmt.Errorf("the editor %s failed: %w", command[0], err)
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to read the edited Dockerfile: %w", err)
+		}
+		edited = string(contents)
+		if strings.TrimSpace(edited) == "" {
+			return "", nil, fmt.Errorf("the edited Dockerfile is empty - aborting")
+		}
+
+		warnings, err = lintDockerfile(edited)
+		if err == nil {
+			return edited, warnings, nil
+		}
+		fmt.Fprintf(os.Stderr, "the edited Dockerfile is invalid: %s\nEdit it again? [Y/n] ", err)
+		answer, readErr := readAnswer(ctx, os.Stdin)
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return "", nil, readErr
+		}
+		if errors.Is(readErr, io.EOF) {
+			fmt.Fprintln(os.Stderr)
+		}
+		// Without an answer, such as when STDIN is not a terminal, the edit is given up on
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer == "n" || answer == "no" || (readErr != nil && answer == "") {
+			return "", nil, fmt.Errorf("the edited Dockerfile is invalid: %w", err)
+		}
+	}
+}
+
+// readAnswer reads a line from the terminal, giving up when ctx is cancelled. It reads a byte at a
+// time rather than through a buffer, so whatever is typed after the line is left to the editor
+// opened next. A read given up on is abandoned along with the edit.
+func readAnswer(ctx context.Context, input io.Reader) (answer string, err error) {
+	type line struct {
+		answer string
+		err    error
+	}
+	lines := make(chan line, 1)
+	go func() {
+		var answer []byte
+		b := make([]byte, 1)
+		for {
+			n, err := input.Read(b)
+			if n > 0 {
+				answer = append(answer, b[0])
+				if b[0] == '\n' {
+					lines <- line{string(answer), nil}
+					return
+				}
+			}
+			if err != nil {
+				lines <- line{string(answer), err}
+				return
+			}
+		}
+	}()
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case l := <-lines:
+		return l.answer, l.err
+	}
+}
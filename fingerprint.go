@@ -0,0 +1,134 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+)
+
+// baseFingerprint is what the oldest steps of an image built on a common base image look like.
+// Official images are rebuilt whenever a package is updated, so their layer digests change every
+// few weeks while the steps that build them stay the same.
+type baseFingerprint struct {
+	// steps match the oldest steps of the image in order, as getStep writes them. A pattern may
+	// match several steps in a row.
+	steps []*regexp.Regexp
+	// name makes the name of the base image from the submatches of all the steps
+	name func(submatches []string) string
+	// env is a variable the config must set, as NAME=value, if any
+	env string
+}
+
+// BASE_FINGERPRINTS identify the common base images without the images or a registry, so even
+// offline. Amazon Linux records nothing that tells it apart from other images, and is left to
+// layer matching and --lookup-bases.
+var BASE_FINGERPRINTS = []baseFingerprint{
+	{
+		// The official alpine images add the minirootfs release, e.g. alpine-minirootfs-3.20.0-x86_64.tar.gz
+		steps: fingerprintSteps(`^ADD alpine-minirootfs-(\d+\.\d+\.\d+)-\w+\.tar\.gz /$`, `^CMD \["/bin/sh"\]$`),
+		name: func(submatches []string) string {
+			return "alpine:" + submatches[0]
+		},
+	},
+	{
+		// The official debian images record the debuerreotype script that built their root filesystem
+		steps: fingerprintSteps(`^RUN # debian\.sh (.*\s)?out/ '(\w+)' '@\d+'$`, `^CMD \["bash"\]$`),
+		name: func(submatches []string) string {
+			if strings.Contains(submatches[0], "--slim") {
+				return "debian:" + submatches[1] + "-slim"
+			}
+			return "debian:" + submatches[1]
+		},
+	},
+	{
+		steps: fingerprintSteps(
+			`^ARG RELEASE$`,
+			`^ARG LAUNCHPAD_BUILD_ARCH$`,
+			`^LABEL org\.opencontainers\.image\.ref\.name=ubuntu$`,
+			`^LABEL org\.opencontainers\.image\.version=(\d+\.\d+)$`,
+			`^ADD \S+ (in )?/$`,
+			`^CMD \["/bin/bash"\]$`,
+		),
+		name: func(submatches []string) string {
+			return "ubuntu:" + submatches[0]
+		},
+	},
+	{
+		// The official busybox images add busybox.tar.xz. Those built by the classic builder record
+		// it by its hash, like any other root filesystem, so only the file name is matched. They
+		// record no version, so only the repository is named.
+		steps: fingerprintSteps(`^ADD busybox\.tar\.xz /$`, `^CMD \["sh"\]$`),
+		name: func(submatches []string) string {
+			return "busybox"
+		},
+	},
+	{
+		// Bazel leaves a placeholder for every layer of the distroless images
+		steps: fingerprintSteps(`^RUN bazel build \.\.\.$`),
+		name: func(submatches []string) string {
+			return "gcr.io/distroless/static"
+		},
+		env: "SSL_CERT_FILE=/etc/ssl/certs/ca-certificates.crt",
+	},
+}
+
+func fingerprintSteps(patterns ...string) (steps []*regexp.Regexp) {
+	for _, pattern := range patterns {
+		steps = append(steps, regexp.MustCompile(pattern))
+	}
+	return steps
+}
+
+// match returns the base image and the created_by of its newest step when the oldest steps of the
+// history, which is newest first, match the fingerprint.
+func (f baseFingerprint) match(imageHistory []image.HistoryResponseItem, config *container.Config) (baseImage string, lastStep string, ok bool) {
+	if f.env != "" && (config == nil || !slices.Contains(config.Env, f.env)) {
+		return "", "", false
+	}
+	var submatches []string
+	next := 0
+	for i := len(imageHistory) - 1; i >= 0; i-- {
+		// Podman leaves created_by empty for layers it has no command for
+		if strings.TrimSpace(imageHistory[i].CreatedBy) == "" {
+			continue
+		}
+		step := getStep(imageHistory[i].CreatedBy)
+		if next > 0 && f.steps[next-1].MatchString(step) {
+			lastStep = imageHistory[i].CreatedBy
+			continue
+		}
+		if next == len(f.steps) {
+			break
+		}
+		match := f.steps[next].FindStringSubmatch(step)
+		if match == nil {
+			return "", "", false
+		}
+		submatches = append(submatches, match[1:]...)
+		lastStep = imageHistory[i].CreatedBy
+		next++
+	}
+	if next < len(f.steps) {
+		return "", "", false
+	}
+	return f.name(submatches), lastStep, true
+}
+
+// fingerprintBase identifies the base image by BASE_FINGERPRINTS. A Buildah build records its
+// base, which is exact, so its history is left alone.
+func fingerprintBase(imageHistory []image.HistoryResponseItem, config *container.Config) (baseImage string, lastStep string, ok bool) {
+	for _, imageEvent := range imageHistory {
+		if strings.HasPrefix(imageEvent.Comment, "FROM ") {
+			return "", "", false
+		}
+	}
+	for _, fingerprint := range BASE_FINGERPRINTS {
+		if baseImage, lastStep, ok = fingerprint.match(imageHistory, config); ok {
+			return baseImage, lastStep, true
+		}
+	}
+	return "", "", false
+}
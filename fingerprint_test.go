@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+)
+
+// history turns the created_by of the steps of an image, oldest first as a Dockerfile lists them,
+// into a history newest first as the daemon returns it.
+func history(steps ...string) (imageHistory []image.HistoryResponseItem) {
+	for i := len(steps) - 1; i >= 0; i-- {
+		imageHistory = append(imageHistory, image.HistoryResponseItem{CreatedBy: steps[i]})
+	}
+	return imageHistory
+}
+
+func TestFingerprintBase(t *testing.T) {
+	tests := []struct {
+		name      string
+		history   []image.HistoryResponseItem
+		config    *container.Config
+		baseImage string
+		lastStep  string
+	}{
+		{
+			name: "alpine",
+			history: history(
+				"ADD alpine-minirootfs-3.20.3-x86_64.tar.gz / # buildkit",
+				`CMD ["/bin/sh"]`,
+				"RUN /bin/sh -c apk add --no-cache curl # buildkit",
+			),
+			baseImage: "alpine:3.20.3",
+			lastStep:  `CMD ["/bin/sh"]`,
+		},
+		{
+			name: "debian",
+			history: history(
+				"# debian.sh --arch 'amd64' out/ 'bookworm' '@1729468800'",
+				`CMD ["bash"]`,
+				"RUN /bin/sh -c apt-get update # buildkit",
+			),
+			baseImage: "debian:bookworm",
+			lastStep:  `CMD ["bash"]`,
+		},
+		{
+			name: "debian slim",
+			history: history(
+				"# debian.sh --arch 'arm64' --slim out/ 'bullseye' '@1729468800'",
+				`CMD ["bash"]`,
+			),
+			baseImage: "debian:bullseye-slim",
+			lastStep:  `CMD ["bash"]`,
+		},
+		{
+			name: "ubuntu",
+			history: history(
+				"/bin/sh -c #(nop)  ARG RELEASE",
+				"/bin/sh -c #(nop)  ARG LAUNCHPAD_BUILD_ARCH",
+				"/bin/sh -c #(nop)  LABEL org.opencontainers.image.ref.name=ubuntu",
+				"/bin/sh -c #(nop)  LABEL org.opencontainers.image.version=24.04",
+				"/bin/sh -c #(nop) ADD file:a6d4a5e0b2a4e8c5bb4c4c1f9b7a3b8d6e0f0a1b2c3d4e5f60718293a4b5c6d7 in / ",
+				`/bin/sh -c #(nop)  CMD ["/bin/bash"]`,
+				"RUN /bin/sh -c apt-get update # buildkit",
+			),
+			baseImage: "ubuntu:24.04",
+			lastStep:  `/bin/sh -c #(nop)  CMD ["/bin/bash"]`,
+		},
+		{
+			name: "busybox",
+			history: history(
+				"ADD busybox.tar.xz / # buildkit",
+				`CMD ["sh"]`,
+				"COPY app /bin/app # buildkit",
+			),
+			baseImage: "busybox",
+			lastStep:  `CMD ["sh"]`,
+		},
+		{
+			name: "root filesystem by hash that runs sh",
+			history: history(
+				"/bin/sh -c #(nop) ADD file:7e9002edaafd4e4579b65c8f0aaabde1aeb7fd3f8d95579f7b07a8a6f8fd8ebd in / ",
+				`/bin/sh -c #(nop)  CMD ["sh"]`,
+			),
+		},
+		{
+			name: "distroless",
+			history: history(
+				"bazel build ...",
+				"bazel build ...",
+				"bazel build ...",
+				"COPY /out/server /server # buildkit",
+			),
+			config:    &container.Config{Env: []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin", "SSL_CERT_FILE=/etc/ssl/certs/ca-certificates.crt"}},
+			baseImage: "gcr.io/distroless/static",
+			lastStep:  "bazel build ...",
+		},
+		{
+			name: "distroless steps without its config",
+			history: history(
+				"bazel build ...",
+				"COPY /out/server /server # buildkit",
+			),
+			config: &container.Config{Env: []string{"PATH=/usr/bin:/bin"}},
+		},
+		{
+			name: "custom root filesystem",
+			history: history(
+				"ADD rootfs.tar.xz / # buildkit",
+				`CMD ["sh"]`,
+			),
+		},
+		{
+			name: "alpine steps after other steps",
+			history: history(
+				"ADD rootfs.tar.xz / # buildkit",
+				"ADD alpine-minirootfs-3.20.3-x86_64.tar.gz / # buildkit",
+				`CMD ["/bin/sh"]`,
+			),
+		},
+		{
+			name: "Buildah records the base",
+			history: []image.HistoryResponseItem{
+				{CreatedBy: "/bin/sh -c make", Comment: "FROM docker.io/library/alpine:3.20"},
+				{CreatedBy: `/bin/sh -c #(nop)  CMD ["/bin/sh"]`},
+				{CreatedBy: "ADD alpine-minirootfs-3.20.3-x86_64.tar.gz / # buildkit"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseImage, lastStep, ok := fingerprintBase(test.history, test.config)
+			if ok != (test.baseImage != "") || baseImage != test.baseImage || lastStep != test.lastStep {
+				t.Errorf("fingerprintBase() = %q, %q, %t, want %q, %q", baseImage, lastStep, ok, test.baseImage, test.lastStep)
+			}
+		})
+	}
+}
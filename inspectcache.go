@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+)
+
+// cachedInspection is what the daemon said about one image. An image ID is the digest of its
+// config, so everything but the tags stays true for as long as the image exists.
+type cachedInspection struct {
+	Created time.Time `json:"created"`
+	// Source is the daemon the responses came from, as backendSource tells it
+	Source  string                      `json:"source,omitempty"`
+	ImageID string                      `json:"image_id,omitempty"`
+	Inspect *types.ImageInspect         `json:"inspect,omitempty"`
+	History []image.HistoryResponseItem `json:"history,omitempty"`
+}
+
+// cachingBackend remembers the inspect and history responses of a daemon by the daemon and the
+// image ID, so that daemons with an image of the same ID never share what was said about it. An
+// entry is only served while its image is in the latest image list, with the tags of that list.
+type cachingBackend struct {
+	imageBackend
+	// source is the daemon, as backendSource tells it
+	source string
+	// refresh skips reading the cache but still stores the fresh responses
+	refresh bool
+	mu      sync.Mutex
+	listed  []image.Summary
+	// writeMu keeps the inspect and history of one image from being stored over each other
+	writeMu sync.Mutex
+}
+
+func newCachingBackend(cli imageBackend, refresh bool) *cachingBackend {
+	return &cachingBackend{imageBackend: cli, source: backendSource(cli), refresh: refresh}
+}
+
+func inspectCacheDir() (dir string, err error) {
+	return cacheSubdir("inspect")
+}
+
+func (c *cachingBackend) ImageList(ctx context.Context, options image.ListOptions) (imageList []image.Summary, err error) {
+	imageList, err = c.imageBackend.ImageList(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.listed = imageList
+	c.mu.Unlock()
+	return imageList, nil
+}
+
+// listedImage finds the listed image an ID or a name refers to.
+func (c *cachingBackend) listedImage(imageId string) (img image.Summary, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	normalized := normalizeImageName(imageId)
+	for _, img := range c.listed {
+		if img.ID == imageId || hasImageName(img, normalized) {
+			return img, true
+		}
+	}
+	return img, false
+}
+
+func (c *cachingBackend) ImageInspectWithRaw(ctx context.Context, imageId string) (inspect types.ImageInspect, raw []byte, err error) {
+	img, listed := c.listedImage(imageId)
+	if listed && !c.refresh {
+		if entry, ok := readCachedInspection(c.source, img.ID); ok && entry.Inspect != nil {
+			inspect = *entry.Inspect
+			inspect.RepoTags = img.RepoTags
+			inspect.RepoDigests = img.RepoDigests
+			raw, err = json.Marshal(inspect)
+			return inspect, raw, err
+		}
+	}
+	inspect, raw, err = c.imageBackend.ImageInspectWithRaw(ctx, imageId)
+	if err == nil && listed && inspect.ID == img.ID {
+		c.updateCachedInspection(img.ID, func(entry *cachedInspection) { entry.Inspect = &inspect })
+	}
+	return inspect, raw, err
+}
+
+func (c *cachingBackend) ImageHistory(ctx context.Context, imageId string) (imageHistory []image.HistoryResponseItem, err error) {
+	img, listed := c.listedImage(imageId)
+	if listed && !c.refresh {
+		if entry, ok := readCachedInspection(c.source, img.ID); ok && entry.History != nil {
+			return entry.History, nil
+		}
+	}
+	imageHistory, err = c.imageBackend.ImageHistory(ctx, imageId)
+	if err == nil && listed {
+		c.updateCachedInspection(img.ID, func(entry *cachedInspection) { entry.History = imageHistory })
+	}
+	return imageHistory, err
+}
+
+// inspectCachePath names the entry of an image by a hash of the daemon and the image ID.
+func inspectCachePath(source string, imageId string) (path string, err error) {
+	dir, err := inspectCacheDir()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "source=%s\n", source)
+	fmt.Fprintf(h, "image=%s\n", imageId)
+	return filepath.Join(dir, hex.EncodeToString(h.Sum(nil))+".json"), nil
+}
+
+func readCachedInspection(source string, imageId string) (entry cachedInspection, ok bool) {
+	path, err := inspectCachePath(source, imageId)
+	if err != nil {
+		return entry, false
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return entry, false
+	}
+	if json.Unmarshal(contents, &entry) != nil || entry.Source != source || entry.ImageID != imageId {
+		return entry, false
+	}
+	return entry, true
+}
+
+// updateCachedInspection adds a response to the entry of an image. Caching is best effort, so a
+// failure only costs the next run another request.
+func (c *cachingBackend) updateCachedInspection(imageId string, update func(entry *cachedInspection)) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	entry, _ := readCachedInspection(c.source, imageId)
+	entry.Created = time.Now()
+	entry.Source = c.source
+	entry.ImageID = imageId
+	update(&entry)
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	path, err := inspectCachePath(c.source, imageId)
+	if err != nil {
+		return
+	}
+	writeCacheFile(path, contents)
+}
+
+func readInspectCacheEntries() (entries []cacheEntry, err error) {
+	dir, err := inspectCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		// An unreadable entry, or one written before entries recorded their image, has no image ID
+		// and is pruned
+		entry := cacheEntry{Path: path, Size: info.Size(), Kind: CACHE_INSPECTION}
+		entry.Result.Created = info.ModTime()
+		var inspection cachedInspection
+		if contents, err := os.ReadFile(path); err == nil && json.Unmarshal(contents, &inspection) == nil {
+			entry.Result.Source = inspection.Source
+			entry.Result.Extraction.ImageID = inspection.ImageID
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
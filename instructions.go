@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/go-connections/nat"
+)
+
+// The name of a variable set by ENV or the key of a LABEL, and where the next one starts in the
+// arguments of the step
+var (
+	envName      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+	nextEnvName  = regexp.MustCompile(`\s+[A-Za-z_][A-Za-z0-9_]*=`)
+	labelKey     = regexp.MustCompile(`^[^\s="]+=`)
+	nextLabelKey = regexp.MustCompile(`\s+[^\s="]+=`)
+)
+
+// A value that needs no quotes in a Dockerfile
+var safeValue = regexp.MustCompile(`^[A-Za-z0-9_./:,@%+=-]+$`)
+
+// Inside double quotes, the Dockerfile parser takes a backslash, a quote and a $ literally only when
+// they are escaped. A newline would end the instruction and no escape keeps it in the value, so it is
+// written as \n, as is a carriage return as \r.
+var valueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`)
+
+// quoteValue quotes a value of ENV or LABEL when it has spaces or characters the Dockerfile parser
+// would otherwise interpret.
+func quoteValue(value string) string {
+	if safeValue.MatchString(value) {
+		return value
+	}
+	return `"` + valueEscaper.Replace(value) + `"`
+}
+
+// formatEnv writes the arguments of an ENV step as KEY=value pairs with quoted values.
+func formatEnv(args string, env []string) (instruction string) {
+	final := map[string]string{}
+	for _, variable := range env {
+		name, value, _ := strings.Cut(variable, "=")
+		final[name] = value
+	}
+
+	args = strings.TrimSpace(args)
+	// The legacy form sets a single variable, e.g. ENV GREETING hello world
+	if !envName.MatchString(args) {
+		name, value, ok := strings.Cut(args, " ")
+		if !ok {
+			return "ENV " + args
+		}
+		return "ENV " + name + "=" + quoteValue(strings.TrimSpace(value))
+	}
+	return "ENV " + strings.Join(splitPairs(args, final, envName, nextEnvName), " ")
+}
+
+// formatLabel writes the arguments of a LABEL step as key=value pairs with quoted values.
+func formatLabel(args string, labels map[string]string) (instruction string) {
+	return "LABEL " + strings.Join(splitPairs(strings.TrimSpace(args), labels, labelKey, nextLabelKey), " ")
+}
+
+// splitPairs splits the arguments of an ENV or LABEL step into key=value pairs with quoted values.
+// The history records the values unquoted, so a value with spaces is told from the next pair by the
+// value in the image config where it is still the final one, and otherwise runs up to the next
+// key=.
+func splitPairs(args string, final map[string]string, key *regexp.Regexp, nextKey *regexp.Regexp) (pairs []string) {
+	rest := args
+	for rest != "" {
+		match := key.FindString(rest)
+		if match == "" {
+			// Whatever follows cannot be parsed and is kept as it was
+			return append(pairs, standardizeSpaces(rest))
+		}
+		name := strings.TrimSuffix(match, "=")
+		rest = rest[len(match):]
+		value, ok := final[name]
+		switch {
+		case ok && (rest == value || strings.HasPrefix(rest, value+" ")):
+			rest = rest[len(value):]
+		case ok && rest == strings.TrimRight(value, " \t"):
+			// The trailing spaces of the last value were trimmed along with the step
+			rest = ""
+		default:
+			value = rest
+			if next := nextKey.FindStringIndex(rest); next != nil {
+				value = rest[:next[0]]
+			}
+			rest = rest[len(value):]
+		}
+		rest = strings.TrimLeft(rest, " ")
+		pairs = append(pairs, name+"="+quoteValue(value))
+	}
+	return pairs
+}
+
+// A key=value pair written by formatLabel
+var labelPair = regexp.MustCompile(`[^\s="]+=("(\\.|[^"\\])*"|\S*)`)
+
+// groupLabels merges consecutive LABEL instructions, which add no layer between them, into one
+// LABEL with a key on every line.
+func groupLabels(dockerCommands []string, builders []string) (commands []string, commandBuilders []string) {
+	var grouped []string
+	for i, command := range dockerCommands {
+		args, ok := strings.CutPrefix(command, "LABEL ")
+		if !ok {
+			commands = append(commands, command)
+			commandBuilders = append(commandBuilders, builders[i])
+			grouped = nil
+			continue
+		}
+		pairs := labelPair.FindAllString(args, -1)
+		if grouped == nil {
+			commands = append(commands, "")
+			commandBuilders = append(commandBuilders, builders[i])
+		}
+		grouped = append(grouped, pairs...)
+		commands[len(commands)-1] = "LABEL " + strings.Join(grouped, " \\\n      ")
+	}
+	return commands, commandBuilders
+}
+
+// DEFAULT_SHELLS are the shells a shell-form CMD or ENTRYPOINT runs with when no SHELL is set, on
+// Linux and on Windows.
+var DEFAULT_SHELLS = [][]string{{"/bin/sh", "-c"}, {"cmd", "/S", "/C"}}
+
+// parseQuotedList parses the arguments of a CMD or ENTRYPOINT step, which the builders record as a
+// list of Go quoted strings, e.g. ["/bin/sh" "-c" "nginx -g 'daemon off;'"].
+func parseQuotedList(args string) (list []string, ok bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(args), "[")
+	if !ok {
+		return nil, false
+	}
+	list = []string{}
+	for {
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "]" {
+			return list, true
+		}
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, false
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, false
+		}
+		list = append(list, value)
+		rest = rest[len(quoted):]
+	}
+}
+
+// execForm writes a command as a JSON array, the exec form of CMD and ENTRYPOINT.
+func execForm(command []string) string {
+	var quoted []string
+	for _, arg := range command {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(arg)
+		quoted = append(quoted, strings.TrimSuffix(buf.String(), "\n"))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// formatCommand writes a CMD or ENTRYPOINT step in the form it was declared in. Both forms are
+// recorded as a list, the shell form being the command line run by the shell, so a list made of
+// the shell and a single command line is written back in shell form and any other as JSON. The
+// final CMD or ENTRYPOINT is taken from the image config, which holds it exactly.
+func formatCommand(keyword string, args string, final []string, shell []string) (instruction string) {
+	command, ok := parseQuotedList(args)
+	if len(final) > 0 {
+		command, ok = final, true
+	}
+	if !ok {
+		return keyword + " " + standardizeSpaces(args)
+	}
+	shells := DEFAULT_SHELLS
+	if len(shell) > 0 {
+		shells = [][]string{shell}
+	}
+	for _, shell := range shells {
+		if len(command) == len(shell)+1 && slices.Equal(command[:len(shell)], shell) && !strings.Contains(command[len(shell)], "\n") {
+			return keyword + " " + command[len(shell)]
+		}
+	}
+	return keyword + " " + execForm(command)
+}
+
+// exposedPorts parses the arguments of an EXPOSE step. Older builders record the ports as a Go map,
+// e.g. map[8080/tcp:{} 53/udp:{}].
+func exposedPorts(args string) (ports []string) {
+	args = strings.TrimSpace(args)
+	if inner, ok := strings.CutPrefix(args, "map["); ok {
+		args = strings.TrimSuffix(inner, "]")
+	}
+	for _, port := range strings.Fields(args) {
+		ports = append(ports, strings.TrimSuffix(port, ":{}"))
+	}
+	return ports
+}
+
+// formatPort writes a port the way it is usually declared, without the default tcp protocol.
+func formatPort(port string) string {
+	return strings.TrimSuffix(port, "/tcp")
+}
+
+// formatExpose writes the arguments of an EXPOSE step as a list of ports.
+func formatExpose(args string) (instruction string) {
+	var ports []string
+	for _, port := range exposedPorts(args) {
+		ports = append(ports, formatPort(port))
+	}
+	return "EXPOSE " + strings.Join(ports, " ")
+}
+
+// exposeMissingPorts adds an EXPOSE instruction for the ports the image config declares that no
+// EXPOSE step of the history does, as the newest instruction.
+func exposeMissingPorts(dockerCommands []string, builders []string, exposed nat.PortSet) (commands []string, commandBuilders []string) {
+	declared := map[nat.Port]bool{}
+	for _, command := range dockerCommands {
+		if args, ok := strings.CutPrefix(command, "EXPOSE "); ok {
+			for _, port := range strings.Fields(args) {
+				// A range declares every port in it
+				proto, number := nat.SplitProtoPort(port)
+				start, end, err := nat.ParsePortRange(number)
+				if err != nil {
+					continue
+				}
+				for n := start; n <= end; n++ {
+					declared[nat.Port(fmt.Sprintf("%d/%s", n, proto))] = true
+				}
+			}
+		}
+	}
+	var missing []nat.Port
+	for port := range exposed {
+		if !declared[port] {
+			missing = append(missing, port)
+		}
+	}
+	if len(missing) == 0 {
+		return dockerCommands, builders
+	}
+	slices.SortFunc(missing, func(a, b nat.Port) int {
+		if a.Int() != b.Int() {
+			return a.Int() - b.Int()
+		}
+		return strings.Compare(a.Proto(), b.Proto())
+	})
+	var ports []string
+	for _, port := range missing {
+		ports = append(ports, formatPort(string(port)))
+	}
+	commands = append([]string{"EXPOSE " + strings.Join(ports, " ")}, dockerCommands...)
+	commandBuilders = append([]string{""}, builders...)
+	return commands, commandBuilders
+}
+
+// volumePaths parses the arguments of a VOLUME step, which the builders record as a Go slice, e.g.
+// [/data /var/log]. A path with spaces is told from the next one by the volumes in the image config.
+func volumePaths(args string, volumes map[string]struct{}) (paths []string) {
+	args = strings.TrimSpace(args)
+	if inner, ok := strings.CutPrefix(args, "["); ok {
+		args = strings.TrimSuffix(inner, "]")
+	}
+	// The longest paths are tried first, so /my data is not taken for /my
+	var known []string
+	for volume := range volumes {
+		known = append(known, volume)
+	}
+	slices.SortFunc(known, func(a, b string) int {
+		return len(b) - len(a)
+	})
+	rest := strings.TrimSpace(args)
+	for rest != "" {
+		path := rest
+		if end := strings.Index(rest, " "); end >= 0 {
+			path = rest[:end]
+		}
+		for _, volume := range known {
+			if rest == volume || strings.HasPrefix(rest, volume+" ") {
+				path = volume
+				break
+			}
+		}
+		paths = append(paths, path)
+		rest = strings.TrimLeft(rest[len(path):], " ")
+	}
+	return paths
+}
+
+// formatVolume writes a VOLUME instruction, in JSON form when a path would otherwise be split.
+func formatVolume(paths []string) (instruction string) {
+	for _, path := range paths {
+		if strings.ContainsAny(path, " \t\"'\\$") {
+			return "VOLUME " + execForm(paths)
+		}
+	}
+	return "VOLUME " + strings.Join(paths, " ")
+}
+
+// declareMissingVolumes adds a VOLUME instruction for the volumes the image config declares that no
+// VOLUME step of the history does, as the newest instruction.
+func declareMissingVolumes(dockerCommands []string, builders []string, declared []string, volumes map[string]struct{}) (commands []string, commandBuilders []string) {
+	var missing []string
+	for volume := range volumes {
+		if !slices.Contains(declared, volume) {
+			missing = append(missing, volume)
+		}
+	}
+	if len(missing) == 0 {
+		return dockerCommands, builders
+	}
+	slices.Sort(missing)
+	commands = append([]string{formatVolume(missing)}, dockerCommands...)
+	commandBuilders = append([]string{""}, builders...)
+	return commands, commandBuilders
+}
+
+// resolveWorkdir is the working directory a WORKDIR instruction sets, which is relative to the one
+// before it unless it is absolute.
+func resolveWorkdir(current string, dir string) string {
+	if current == "" || path.IsAbs(dir) || strings.HasPrefix(dir, "$") {
+		return dir
+	}
+	return path.Join(current, dir)
+}
+
+// setWorkingDir adds a WORKDIR instruction for the working directory of the image config when the
+// WORKDIR steps of the history do not end there, e.g. because they were squashed away or the base
+// image set it, as the newest instruction.
+func setWorkingDir(dockerCommands []string, builders []string, workingDir string) (commands []string, commandBuilders []string) {
+	var current string
+	for i := len(dockerCommands) - 1; i >= 0; i-- {
+		if dir, ok := strings.CutPrefix(dockerCommands[i], "WORKDIR "); ok {
+			current = resolveWorkdir(current, dir)
+		}
+	}
+	if workingDir == "" || path.Clean(workingDir) == path.Clean(current) {
+		return dockerCommands, builders
+	}
+	commands = append([]string{"WORKDIR " + workingDir}, dockerCommands...)
+	commandBuilders = append([]string{""}, builders...)
+	return commands, commandBuilders
+}
+
+// collapseWorkdirs replaces consecutive WORKDIR instructions with a single one setting the working
+// directory they end in.
+func collapseWorkdirs(dockerCommands []string, builders []string) (commands []string, commandBuilders []string) {
+	var current string
+	collapsing := false
+	for i, command := range dockerCommands {
+		dir, ok := strings.CutPrefix(command, "WORKDIR ")
+		if !ok {
+			commands = append(commands, command)
+			commandBuilders = append(commandBuilders, builders[i])
+			collapsing = false
+			continue
+		}
+		current = resolveWorkdir(current, dir)
+		if collapsing {
+			commands[len(commands)-1] = "WORKDIR " + current
+			continue
+		}
+		commands = append(commands, command)
+		commandBuilders = append(commandBuilders, builders[i])
+		collapsing = true
+	}
+	return commands, commandBuilders
+}
+
+// setInstruction adds an instruction setting what the image config holds, such as the user, when
+// the steps of the history with that keyword do not end with it, e.g. because the base image set
+// it, as the newest instruction. Without it the Dockerfile of a non-root image would run as root.
+func setInstruction(dockerCommands []string, builders []string, keyword string, value string) (commands []string, commandBuilders []string) {
+	for _, command := range dockerCommands {
+		if last, ok := strings.CutPrefix(command, keyword+" "); ok {
+			if last == value {
+				return dockerCommands, builders
+			}
+			break
+		}
+	}
+	if value == "" {
+		return dockerCommands, builders
+	}
+	commands = append([]string{keyword + " " + value}, dockerCommands...)
+	commandBuilders = append([]string{""}, builders...)
+	return commands, commandBuilders
+}
+
+// parseShell parses the arguments of a SHELL step, which the builders record as a Go slice, e.g.
+// [powershell -Command].
+func parseShell(args string) (shell []string) {
+	if list, ok := parseQuotedList(args); ok {
+		return list
+	}
+	args = strings.TrimSpace(args)
+	if inner, ok := strings.CutPrefix(args, "["); ok {
+		args = strings.TrimSuffix(inner, "]")
+	}
+	return strings.Fields(args)
+}
+
+// stepShells tells the shell every step of the history, newest first, runs with: the shell set by
+// the newest SHELL step before it, or the one a SHELL step sets itself. Steps before any SHELL step
+// run with the default shell and get nil. The newest SHELL step is taken from the image config,
+// which keeps arguments with spaces whole.
+func stepShells(imageHistory []image.HistoryResponseItem, final []string) (shells [][]string) {
+	shells = make([][]string, len(imageHistory))
+	var current []string
+	newest := -1
+	for i := len(imageHistory) - 1; i >= 0; i-- {
+		if args, ok := strings.CutPrefix(getStep(imageHistory[i].CreatedBy), "SHELL "); ok {
+			current = parseShell(args)
+			newest = i
+		}
+		shells[i] = current
+	}
+	if newest >= 0 && len(final) > 0 {
+		for i := newest; i >= 0; i-- {
+			shells[i] = final
+		}
+	}
+	return shells
+}
+
+// The source of a COPY or ADD step of the classic builder and Buildah is the hash of its content,
+// e.g. file:d0764a71... in /, and the flags in front of it may lack the space after them, as in
+// --chown=1000:1000dir:7927...
+var copySource = regexp.MustCompile(`(file|dir|multi):([0-9a-f]+) in (.*)$`)
+
+// COPY_PLACEHOLDERS stand in for the sources of a COPY or ADD step, by the kind of its content hash.
+var COPY_PLACEHOLDERS = map[string]string{
+	"file":  "<file>",
+	"dir":   "<directory>",
+	"multi": "<files>",
+}
+
+// formatCopy writes a COPY or ADD step whose source is a content hash with a placeholder for the
+// source, keeping its flags, such as --chown, --chmod and --from, and returns the content hash. The
+// steps of BuildKit record the sources as they were written and are kept.
+func formatCopy(keyword string, args string) (instruction string, content string) {
+	match := copySource.FindStringSubmatchIndex(args)
+	if match == nil {
+		return keyword + " " + standardizeSpaces(args), ""
+	}
+	flags := strings.Fields(args[:match[0]])
+	kind := args[match[2]:match[3]]
+	destination := strings.TrimSpace(args[match[6]:match[7]])
+
+	sources := []string{COPY_PLACEHOLDERS[kind], destination}
+	if strings.ContainsAny(destination, " \t") {
+		flags = append(flags, execForm(sources))
+	} else {
+		flags = append(flags, sources...)
+	}
+	return keyword + " " + strings.Join(flags, " "), args[match[2]:match[5]]
+}
+
+// copiedContents matches the content hashes of the COPY and ADD steps, in the order of the
+// instructions, with the instructions formatCopy wrote for them.
+func copiedContents(dockerCommands []string, contents []string) (matched []string) {
+	if len(contents) == 0 {
+		return nil
+	}
+	matched = make([]string, len(dockerCommands))
+	next := 0
+	for i, command := range dockerCommands {
+		keyword, args, _ := strings.Cut(command, " ")
+		if next == len(contents) || (keyword != "COPY" && keyword != "ADD") {
+			continue
+		}
+		for _, placeholder := range COPY_PLACEHOLDERS {
+			if strings.Contains(args, placeholder+" ") || strings.Contains(args, `"`+placeholder+`"`) {
+				matched[i] = contents[next]
+				next++
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// The root filesystem the first step of an image built FROM scratch adds: an archive, or a single
+// file the classic builder records by its hash, which ADD extracts when it is an archive
+var rootFilesystem = regexp.MustCompile(`^ADD (\S+\.(tar|tar\.gz|tgz|tar\.xz|txz|tar\.bz2|tar\.zst)|<file>) /$`)
+
+// startsFromScratch tells whether the oldest step of the history, the last instruction, adds a root
+// filesystem, as the first step of an image built FROM scratch does, e.g. ADD rootfs.tar.gz /. A
+// COPY, or a root filesystem added on top of another, comes from a base image that was not found.
+func startsFromScratch(dockerCommands []string) bool {
+	last := len(dockerCommands) - 1
+	if last < 0 || !rootFilesystem.MatchString(dockerCommands[last]) {
+		return false
+	}
+	return last == 0 || !rootFilesystem.MatchString(dockerCommands[last-1])
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+// assertSingleInstruction fails the test unless the Dockerfile parser reads the instruction as one.
+func assertSingleInstruction(t *testing.T, instruction string) {
+	t.Helper()
+	result, err := parser.Parse(strings.NewReader(instruction + "\n"))
+	if err != nil {
+		t.Fatalf("unable to parse %q: %s", instruction, err)
+	}
+	if len(result.AST.Children) != 1 {
+		t.Errorf("%q is parsed as %d instructions", instruction, len(result.AST.Children))
+	}
+}
+
+func TestFormatEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+		env  []string
+		want string
+	}{
+		{
+			name: "single variable",
+			args: "PATH=/usr/local/bin:/usr/bin",
+			want: "ENV PATH=/usr/local/bin:/usr/bin",
+		},
+		{
+			name: "several variables",
+			args: "LANG=C.UTF-8 TZ=UTC",
+			want: "ENV LANG=C.UTF-8 TZ=UTC",
+		},
+		{
+			name: "legacy form",
+			args: "GREETING hello world",
+			want: `ENV GREETING="hello world"`,
+		},
+		{
+			name: "value with spaces",
+			args: "JAVA_OPTS=-Xmx512m -Xms256m",
+			want: `ENV JAVA_OPTS="-Xmx512m -Xms256m"`,
+		},
+		{
+			name: "config tells a value with spaces from the next pair",
+			args: "OPTS=-v X=1 MODE=prod",
+			env:  []string{"OPTS=-v X=1", "MODE=prod"},
+			want: `ENV OPTS="-v X=1" MODE=prod`,
+		},
+		{
+			name: "without the config a value ends at the next name",
+			args: "OPTS=-v X=1 MODE=prod",
+			want: "ENV OPTS=-v X=1 MODE=prod",
+		},
+		{
+			name: "quotes are escaped",
+			args: `MSG=say "hi"`,
+			want: `ENV MSG="say \"hi\""`,
+		},
+		{
+			name: "dollar and backslash are escaped",
+			args: `PS1=\u@\h $ `,
+			env:  []string{`PS1=\u@\h $ `},
+			want: `ENV PS1="\\u@\\h \$ "`,
+		},
+		{
+			name: "trailing spaces of the last value come from the config",
+			args: "PROMPT=>",
+			env:  []string{"PROMPT=>  "},
+			want: `ENV PROMPT=">  "`,
+		},
+		{
+			name: "newline",
+			args: "MOTD=line one\nline two",
+			env:  []string{"MOTD=line one\nline two"},
+			want: `ENV MOTD="line one\nline two"`,
+		},
+		{
+			name: "empty value",
+			args: "PROXY= MODE=prod",
+			want: `ENV PROXY="" MODE=prod`,
+		},
+		{
+			name: "= in a value",
+			args: "LDFLAGS=-X=main.version=1.2",
+			want: "ENV LDFLAGS=-X=main.version=1.2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := formatEnv(test.args, test.env)
+			if got != test.want {
+				t.Errorf("formatEnv(%q) = %q, want %q", test.args, got, test.want)
+			}
+			assertSingleInstruction(t, got)
+		})
+	}
+}
+
+func TestFormatLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   string
+		labels map[string]string
+		want   string
+	}{
+		{
+			name: "dotted keys",
+			args: "org.opencontainers.image.source=https://example.com/app org.opencontainers.image.version=1.2",
+			want: "LABEL org.opencontainers.image.source=https://example.com/app org.opencontainers.image.version=1.2",
+		},
+		{
+			name:   "value with spaces",
+			args:   "description=A small web server maintainer=me",
+			labels: map[string]string{"description": "A small web server", "maintainer": "me"},
+			want:   `LABEL description="A small web server" maintainer=me`,
+		},
+		{
+			name:   "value with spaces and a key=",
+			args:   "description=set a=b to enable maintainer=me",
+			labels: map[string]string{"description": "set a=b to enable"},
+			want:   `LABEL description="set a=b to enable" maintainer=me`,
+		},
+		{
+			name: "quotes and escapes",
+			args: `motd=it's "fine" \o/`,
+			want: `LABEL motd="it's \"fine\" \\o/"`,
+		},
+		{
+			name:   "newline",
+			args:   "description=first line\nsecond line version=1",
+			labels: map[string]string{"description": "first line\nsecond line"},
+			want:   `LABEL description="first line\nsecond line" version=1`,
+		},
+		{
+			name:   "a label changed by a later step is split at the next key",
+			args:   "description=old text version=1",
+			labels: map[string]string{"description": "new text"},
+			want:   `LABEL description="old text" version=1`,
+		},
+		{
+			name: "unparseable rest is kept",
+			args: `"quoted key"=x`,
+			want: `LABEL "quoted key"=x`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := formatLabel(test.args, test.labels)
+			if got != test.want {
+				t.Errorf("formatLabel(%q) = %q, want %q", test.args, got, test.want)
+			}
+			assertSingleInstruction(t, got)
+		})
+	}
+}
+
+func TestFormatCopy(t *testing.T) {
+	tests := []struct {
+		name        string
+		createdBy   string
+		instruction string
+		content     string
+	}{
+		{
+			name:        "classic builder file",
+			createdBy:   "/bin/sh -c #(nop) COPY file:d0764a717d1e63ba in /app/ ",
+			instruction: "COPY <file> /app/",
+			content:     "file:d0764a717d1e63ba",
+		},
+		{
+			name:        "classic builder directory",
+			createdBy:   "/bin/sh -c #(nop) ADD dir:7927b3c6d86b2f1a in /srv ",
+			instruction: "ADD <directory> /srv",
+			content:     "dir:7927b3c6d86b2f1a",
+		},
+		{
+			name:        "classic builder several sources",
+			createdBy:   "/bin/sh -c #(nop) COPY multi:4f2e1b7c9a in /etc/app/ ",
+			instruction: "COPY <files> /etc/app/",
+			content:     "multi:4f2e1b7c9a",
+		},
+		{
+			name:        "--chown without the space after it",
+			createdBy:   "/bin/sh -c #(nop) COPY --chown=1000:1000dir:7927b3c6d86b in /home/app ",
+			instruction: "COPY --chown=1000:1000 <directory> /home/app",
+			content:     "dir:7927b3c6d86b",
+		},
+		{
+			name:        "--chown and --chmod",
+			createdBy:   "/bin/sh -c #(nop) COPY --chown=app:app --chmod=755 file:3a9f0c in /usr/local/bin/entrypoint.sh ",
+			instruction: "COPY --chown=app:app --chmod=755 <file> /usr/local/bin/entrypoint.sh",
+			content:     "file:3a9f0c",
+		},
+		{
+			name:        "--from",
+			createdBy:   "/bin/sh -c #(nop) COPY --from=builder file:b1e4 in /usr/local/bin/app ",
+			instruction: "COPY --from=builder <file> /usr/local/bin/app",
+			content:     "file:b1e4",
+		},
+		{
+			name:        "destination with a space",
+			createdBy:   "/bin/sh -c #(nop) COPY file:c0ffee in /opt/my app/ ",
+			instruction: `COPY ["<file>", "/opt/my app/"]`,
+			content:     "file:c0ffee",
+		},
+		{
+			name:        "BuildKit keeps the sources and flags",
+			createdBy:   "COPY --chown=app:app --chmod=644 config.yaml /etc/app/ # buildkit",
+			instruction: "COPY --chown=app:app --chmod=644 config.yaml /etc/app/",
+		},
+		{
+			name:        "BuildKit --from",
+			createdBy:   "COPY --from=builder /out/app /usr/local/bin/app # buildkit",
+			instruction: "COPY --from=builder /out/app /usr/local/bin/app",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyword, args, _ := strings.Cut(getStep(test.createdBy), " ")
+			instruction, content := formatCopy(keyword, args)
+			if instruction != test.instruction || content != test.content {
+				t.Errorf("formatCopy of %q = %q, %q, want %q, %q", test.createdBy, instruction, content, test.instruction, test.content)
+			}
+		})
+	}
+}
+
+func TestStartsFromScratch(t *testing.T) {
+	tests := []struct {
+		name           string
+		dockerCommands []string
+		want           bool
+	}{
+		{"root filesystem archive", []string{"CMD [\"/bin/sh\"]", "ADD rootfs.tar.gz /"}, true},
+		{"root filesystem by its hash", []string{"ENV PATH=/bin", "ADD <file> /"}, true},
+		{"only step", []string{"ADD busybox.tar.xz /"}, true},
+		{"copied files", []string{"CMD [\"/app\"]", "COPY app /app"}, false},
+		{"copied to the root", []string{"COPY <file> /"}, false},
+		{"archive added elsewhere", []string{"ADD app.tar.gz /opt/"}, false},
+		{"directory", []string{"ADD <directory> /"}, false},
+		{"root filesystem on another one", []string{"ADD overlay.tar.gz /", "ADD rootfs.tar.gz /"}, false},
+		{"command first", []string{"RUN # debian.sh --arch 'amd64' out/ 'bookworm' '@1729468800'"}, false},
+		{"no instructions", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := startsFromScratch(test.dockerCommands); got != test.want {
+				t.Errorf("startsFromScratch(%q) = %t, want %t", test.dockerCommands, got, test.want)
+			}
+		})
+	}
+}
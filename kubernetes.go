@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/reference"
+	"gopkg.in/yaml.v3"
+)
+
+// The subset of a Kubernetes Deployment --format k8s fills in from the image config.
+type k8sDeployment struct {
+	APIVersion string        `yaml:"apiVersion"`
+	Kind       string        `yaml:"kind"`
+	Metadata   k8sMetadata   `yaml:"metadata"`
+	Spec       k8sDeploySpec `yaml:"spec"`
+}
+
+type k8sMetadata struct {
+	Name   string            `yaml:"name,omitempty"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+type k8sDeploySpec struct {
+	Replicas int `yaml:"replicas"`
+	Selector struct {
+		MatchLabels map[string]string `yaml:"matchLabels"`
+	} `yaml:"selector"`
+	Template struct {
+		Metadata k8sMetadata `yaml:"metadata"`
+		Spec     k8sPodSpec  `yaml:"spec"`
+	} `yaml:"template"`
+}
+
+type k8sPodSpec struct {
+	Containers []k8sContainer `yaml:"containers"`
+	Volumes    []k8sVolume    `yaml:"volumes,omitempty"`
+}
+
+type k8sContainer struct {
+	Name            string             `yaml:"name"`
+	Image           string             `yaml:"image"`
+	Command         []string           `yaml:"command,omitempty"`
+	Args            []string           `yaml:"args,omitempty"`
+	WorkingDir      string             `yaml:"workingDir,omitempty"`
+	Ports           []k8sPort          `yaml:"ports,omitempty"`
+	Env             []k8sEnv           `yaml:"env,omitempty"`
+	VolumeMounts    []k8sVolumeMount   `yaml:"volumeMounts,omitempty"`
+	SecurityContext k8sSecurityContext `yaml:"securityContext"`
+}
+
+type k8sPort struct {
+	ContainerPort int    `yaml:"containerPort"`
+	Protocol      string `yaml:"protocol"`
+}
+
+type k8sEnv struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type k8sVolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+}
+
+type k8sVolume struct {
+	Name     string   `yaml:"name"`
+	EmptyDir struct{} `yaml:"emptyDir"`
+}
+
+type k8sSecurityContext struct {
+	RunAsNonRoot             *bool  `yaml:"runAsNonRoot,omitempty"`
+	RunAsUser                *int64 `yaml:"runAsUser,omitempty"`
+	RunAsGroup               *int64 `yaml:"runAsGroup,omitempty"`
+	AllowPrivilegeEscalation bool   `yaml:"allowPrivilegeEscalation"`
+}
+
+var invalidK8sNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// k8sName turns the repository of an image into a valid object name, e.g. ghcr.io/org/my_app:1.0
+// becomes my-app.
+func k8sName(imageName string) (name string) {
+	name = imageName
+	if named, err := reference.ParseNormalizedNamed(imageName); err == nil {
+		name = reference.Path(named)
+	}
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = invalidK8sNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	if name == "" {
+		name = "app"
+	}
+	return name
+}
+
+// renderKubernetes writes a Deployment running the image the way its config would run it. Notes
+// about the user the image runs as are written as comments above it, since they need a decision.
+func renderKubernetes(document jsonDocument) (output string, err error) {
+	config := document.Config
+	name := k8sName(document.Image)
+	labels := map[string]string{"app.kubernetes.io/name": name}
+
+	container := k8sContainer{
+		Name:       name,
+		Image:      document.Image,
+		Command:    config.Entrypoint,
+		Args:       config.Cmd,
+		WorkingDir: config.WorkingDir,
+	}
+	for _, port := range config.ExposedPorts {
+		number, protocol, _ := strings.Cut(port, "/")
+		containerPort, err := strconv.Atoi(number)
+		if err != nil {
+			continue
+		}
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		container.Ports = append(container.Ports, k8sPort{ContainerPort: containerPort, Protocol: strings.ToUpper(protocol)})
+	}
+	// The image defaults are listed so they can be overridden, except PATH which rarely should be
+	for _, variable := range config.Env {
+		key, value, _ := strings.Cut(variable, "=")
+		if key != "PATH" {
+			container.Env = append(container.Env, k8sEnv{Name: key, Value: value})
+		}
+	}
+
+	var notes []string
+	nonRoot := !isRootUser(config.User)
+	userName, groupName, _ := strings.Cut(config.User, ":")
+	uid, uidErr := strconv.ParseInt(userName, 10, 64)
+	switch {
+	case !nonRoot:
+		notes = append(notes, "the image runs as root - set runAsUser to a non-root UID if the image supports it")
+	case uidErr == nil:
+		container.SecurityContext.RunAsNonRoot = &nonRoot
+		container.SecurityContext.RunAsUser = &uid
+		if gid, err := strconv.ParseInt(groupName, 10, 64); err == nil {
+			container.SecurityContext.RunAsGroup = &gid
+		}
+	default:
+		notes = append(notes, fmt.Sprintf("the image runs as the user %s - set runAsUser to its UID and runAsNonRoot to true, which Kubernetes cannot verify for a user name", userName))
+	}
+
+	deployment := k8sDeployment{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Metadata:   k8sMetadata{Name: name, Labels: labels},
+	}
+	deployment.Spec.Replicas = 1
+	deployment.Spec.Selector.MatchLabels = labels
+	deployment.Spec.Template.Metadata = k8sMetadata{Labels: labels}
+	for i, volume := range config.Volumes {
+		volumeName := fmt.Sprintf("volume-%d", i+1)
+		container.VolumeMounts = append(container.VolumeMounts, k8sVolumeMount{Name: volumeName, MountPath: volume})
+		deployment.Spec.Template.Spec.Volumes = append(deployment.Spec.Template.Spec.Volumes, k8sVolume{Name: volumeName})
+	}
+	if len(config.Volumes) > 0 {
+		notes = append(notes, "the image declares volumes, which are mounted as emptyDir - replace them with persistent volumes where the data must survive restarts")
+	}
+	deployment.Spec.Template.Spec.Containers = []k8sContainer{container}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Generated by dfimage %s from %s\n", document.DfimageVersion, document.Image)
+	buf.WriteString(renderWarnings(document.Warnings))
+	for _, note := range notes {
+		fmt.Fprintf(&buf, "# Note: %s\n", note)
+	}
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(deployment)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode the Deployment: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// containerd and buildx record the full image name next to the plain tag in ref.name
+const ANNOTATION_IMAGE_NAME = "io.containerd.image.name"
+
+// splitLayoutReference splits an oci: location into the layout directory and the optional
+// reference after its last colon, leaving a Windows drive letter alone.
+func splitLayoutReference(location string) (layoutPath string, refName string) {
+	volume := filepath.VolumeName(location)
+	rest := location[len(volume):]
+	i := strings.LastIndex(rest, ":")
+	if i < 0 || i < strings.LastIndexAny(rest, `/\`) {
+		return location, ""
+	}
+	return volume + rest[:i], rest[i+1:]
+}
+
+// layoutNames returns the image names recorded for a manifest of an OCI layout. A ref.name that is
+// only a tag, as skopeo and buildx write it, carries no repository and is left out.
+func layoutNames(annotations map[string]string) (names []string) {
+	if imageName := annotations[ANNOTATION_IMAGE_NAME]; imageName != "" {
+		names = append(names, imageName)
+	}
+	if refName := annotations[ocispec.AnnotationRefName]; strings.ContainsAny(refName, "/:") {
+		if _, err := name.ParseReference(refName); err == nil {
+			names = append(names, refName)
+		}
+	}
+	return names
+}
+
+// newOciLayoutBackend reads the images of an OCI image layout, as written by buildx with
+// --output type=oci or by skopeo. A reference after the path limits it to the manifests with that
+// ref.name or image name.
+func newOciLayoutBackend(location string, platform v1.Platform) (backend *indexBackend, err error) {
+	layoutPath, refName := splitLayoutReference(location)
+	if !fileExists(filepath.Join(layoutPath, "index.json")) {
+		return nil, fmt.Errorf("%s is not an OCI image layout - index.json is missing", layoutPath)
+	}
+	return &indexBackend{
+		source: fmt.Sprintf("the OCI layout %s", location),
+		load: func(ctx context.Context) (*ociImageIndex, error) {
+			return loadOciLayout(layoutPath, refName, platform)
+		},
+	}, nil
+}
+
+func loadOciLayout(layoutPath string, refName string, platform v1.Platform) (index *ociImageIndex, err error) {
+	layoutIndex, err := layout.ImageIndexFromPath(layoutPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the OCI layout %s: %w", layoutPath, err)
+	}
+	manifest, err := layoutIndex.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the index of the OCI layout %s: %w", layoutPath, err)
+	}
+
+	index = newOciImageIndex()
+	for _, descriptor := range manifest.Manifests {
+		names := layoutNames(descriptor.Annotations)
+		if refName != "" && descriptor.Annotations[ocispec.AnnotationRefName] != refName && !slices.ContainsFunc(names, func(imageName string) bool {
+			return normalizeImageName(imageName) == normalizeImageName(refName)
+		}) {
+			continue
+		}
+		img, err := layoutImage(layoutIndex, descriptor, platform)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the image %s of the OCI layout %s: %w", descriptor.Digest, layoutPath, err)
+		} else if img == nil {
+			continue
+		}
+		configName, err := img.ConfigName()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the config of the image %s: %w", descriptor.Digest, err)
+		}
+		rawConfig, err := img.RawConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the config of the image %s: %w", descriptor.Digest, err)
+		}
+		var config dockerImage
+		err = json.Unmarshal(rawConfig, &config)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the config of the image %s: %w", descriptor.Digest, err)
+		}
+		imageManifest, err := img.Manifest()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the manifest of the image %s: %w", descriptor.Digest, err)
+		}
+		entry := index.add(configName.String(), config)
+		for _, layer := range imageManifest.Layers {
+			if isForeignLayer(string(layer.MediaType)) {
+				entry.manifest.ForeignLayers++
+			}
+		}
+		entry.manifest.Annotations = imageManifest.Annotations
+		entry.manifest.Provenance = layoutProvenance(layoutIndex, descriptor, img)
+		for _, imageName := range names {
+			index.addName(configName.String(), imageName, descriptor.Digest.String())
+		}
+	}
+	if refName != "" && len(index.images) == 0 {
+		return nil, fmt.Errorf("the OCI layout %s has no image named %s", layoutPath, refName)
+	}
+	index.sortNames()
+	return index, nil
+}
+
+// layoutImage returns the image a manifest of the layout points at. For a multi-platform index
+// that is the image for the platform, or else the first one that is not an attestation.
+func layoutImage(layoutIndex v1.ImageIndex, descriptor v1.Descriptor, platform v1.Platform) (img v1.Image, err error) {
+	switch {
+	case descriptor.MediaType.IsImage():
+		return layoutIndex.Image(descriptor.Digest)
+	case descriptor.MediaType.IsIndex():
+		child, err := layoutIndex.ImageIndex(descriptor.Digest)
+		if err != nil {
+			return nil, err
+		}
+		childManifest, err := child.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		if chosen, ok := choosePlatform(childManifest.Manifests, platform); ok {
+			return child.Image(chosen.Digest)
+		}
+	}
+	return nil, nil
+}
+
+// layoutProvenance returns the provenance BuildKit attached to an image of the layout, which is in
+// the index that holds the image. An attestation that cannot be read only leaves the base image to
+// be found by its layers.
+func layoutProvenance(layoutIndex v1.ImageIndex, descriptor v1.Descriptor, img v1.Image) (statement []byte) {
+	index := layoutIndex
+	if descriptor.MediaType.IsIndex() {
+		child, err := layoutIndex.ImageIndex(descriptor.Digest)
+		if err != nil {
+			return nil
+		}
+		index = child
+	}
+	imageDigest, err := img.Digest()
+	if err != nil {
+		return nil
+	}
+	statement, _ = indexProvenance(index, imageDigest)
+	return statement
+}
+
+// choosePlatform picks the image for the platform from the manifests of a multi-platform index, or
+// else the first one that is not an attestation.
+func choosePlatform(manifests []v1.Descriptor, platform v1.Platform) (chosen v1.Descriptor, ok bool) {
+	for _, candidate := range manifests {
+		if !candidate.MediaType.IsImage() || (candidate.Platform != nil && candidate.Platform.OS == "unknown") {
+			continue
+		}
+		if candidate.Platform != nil && candidate.Platform.Satisfies(platform) {
+			return candidate, true
+		}
+		if !ok {
+			chosen, ok = candidate, true
+		}
+	}
+	return chosen, ok
+}
+
+// newImageDirectoryBackend reads an image copied by skopeo with the dir: transport, which stores the
+// manifest as manifest.json and every blob as a file named after its digest. Such a directory holds
+// no image names, so the image is known by its ID.
+func newImageDirectoryBackend(directory string, platform v1.Platform) (backend *indexBackend, err error) {
+	if !fileExists(filepath.Join(directory, "manifest.json")) {
+		return nil, fmt.Errorf("%s is not an image directory - manifest.json is missing", directory)
+	}
+	return &indexBackend{
+		source: fmt.Sprintf("the image directory %s", directory),
+		load: func(ctx context.Context) (*ociImageIndex, error) {
+			return loadImageDirectory(directory, platform)
+		},
+	}, nil
+}
+
+func loadImageDirectory(directory string, platform v1.Platform) (index *ociImageIndex, err error) {
+	contents, err := os.ReadFile(filepath.Join(directory, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the manifest of %s: %w", directory, err)
+	}
+	manifest, err := parseDirectoryManifest(contents)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the manifest of %s: %w", directory, err)
+	}
+	// skopeo copy --multi-arch all keeps the index as manifest.json and each image manifest next to it
+	if len(manifest.Manifests) > 0 {
+		chosen, ok := choosePlatform(manifest.Manifests, platform)
+		if !ok {
+			return nil, fmt.Errorf("the index of %s holds no image", directory)
+		}
+		contents, err = os.ReadFile(filepath.Join(directory, chosen.Digest.Hex+".manifest.json"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the manifest of the image %s from %s: %w", chosen.Digest, directory, err)
+		}
+		manifest, err = parseDirectoryManifest(contents)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the manifest of the image %s from %s: %w", chosen.Digest, directory, err)
+		}
+	}
+	if manifest.Config.Digest.Hex == "" {
+		return nil, fmt.Errorf("the manifest of %s has no config - schema 1 manifests are not supported", directory)
+	}
+
+	blob, err := os.ReadFile(filepath.Join(directory, manifest.Config.Digest.Hex))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the image config %s from %s: %w", manifest.Config.Digest, directory, err)
+	}
+	var config dockerImage
+	err = json.Unmarshal(blob, &config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the image config %s: %w", manifest.Config.Digest, err)
+	}
+	index = newOciImageIndex()
+	entry := index.add(manifest.Config.Digest.String(), config)
+	for _, layer := range manifest.Layers {
+		if isForeignLayer(string(layer.MediaType)) {
+			entry.manifest.ForeignLayers++
+		}
+	}
+	entry.manifest.Annotations = manifest.Annotations
+	return index, nil
+}
+
+// directoryManifest reads either an image manifest or an index, which skopeo both store as manifest.json.
+type directoryManifest struct {
+	Config      v1.Descriptor     `json:"config"`
+	Layers      []v1.Descriptor   `json:"layers"`
+	Manifests   []v1.Descriptor   `json:"manifests"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+func parseDirectoryManifest(contents []byte) (manifest directoryManifest, err error) {
+	err = json.Unmarshal(contents, &manifest)
+	return manifest, err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/image"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testLayoutImage makes an image for the platform whose only step names it.
+func testLayoutImage(t *testing.T, platform v1.Platform) (img v1.Image) {
+	img, err := mutate.ConfigFile(mutate.MediaType(empty.Image, types.OCIManifestSchema1), &v1.ConfigFile{
+		OS:           platform.OS,
+		Architecture: platform.Architecture,
+		Variant:      platform.Variant,
+		RootFS:       v1.RootFS{Type: "layers"},
+		History:      []v1.History{{CreatedBy: "RUN /bin/sh -c make " + platform.String() + " # buildkit"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// writeTestOciLayout writes a layout holding a multi-platform app image, whose index starts with an
+// attestation, and a tools image named only by its tag.
+func writeTestOciLayout(t *testing.T) (layoutPath string, configs map[string]string) {
+	configs = map[string]string{}
+	index := mutate.IndexMediaType(empty.Index, types.OCIImageIndex)
+	attestation := testLayoutImage(t, v1.Platform{OS: "unknown", Architecture: "unknown"})
+	index = mutate.AppendManifests(index, mutate.IndexAddendum{
+		Add:        attestation,
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"}},
+	})
+	for _, platform := range []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64", Variant: "v8"}} {
+		img := testLayoutImage(t, platform)
+		configName, err := img.ConfigName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		configs[platform.String()] = configName.String()
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &platform}})
+	}
+	tools := testLayoutImage(t, v1.Platform{OS: "linux", Architecture: "s390x"})
+	configName, err := tools.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs["tools"] = configName.String()
+
+	layoutPath = t.TempDir()
+	p, err := layout.Write(layoutPath, empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.AppendIndex(index, layout.WithAnnotations(map[string]string{
+		ocispec.AnnotationRefName: "registry.example.com/app:1.0",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.AppendImage(tools, layout.WithAnnotations(map[string]string{ocispec.AnnotationRefName: "2.0"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layoutPath, configs
+}
+
+func TestOciLayoutBackend(t *testing.T) {
+	layoutPath, configs := writeTestOciLayout(t)
+	tests := []struct {
+		name     string
+		location string
+		platform v1.Platform
+		// images are the IDs the layout lists
+		images  []string
+		wantErr bool
+	}{
+		{
+			name:     "the platform of the app image",
+			location: layoutPath,
+			platform: v1.Platform{OS: "linux", Architecture: "arm64"},
+			images:   []string{configs["linux/arm64/v8"], configs["tools"]},
+		},
+		{
+			name:     "another platform of the app image",
+			location: layoutPath,
+			platform: v1.Platform{OS: "linux", Architecture: "amd64"},
+			images:   []string{configs["linux/amd64"], configs["tools"]},
+		},
+		{
+			name:     "a platform the app image is not built for",
+			location: layoutPath,
+			platform: v1.Platform{OS: "linux", Architecture: "ppc64le"},
+			images:   []string{configs["linux/amd64"], configs["tools"]},
+		},
+		{
+			name:     "the tools image by its tag",
+			location: layoutPath + ":2.0",
+			platform: v1.Platform{OS: "linux", Architecture: "amd64"},
+			images:   []string{configs["tools"]},
+		},
+		{
+			name:     "a tag the layout does not hold",
+			location: layoutPath + ":3.0",
+			platform: v1.Platform{OS: "linux", Architecture: "amd64"},
+			wantErr:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cli, err := newOciLayoutBackend(test.location, test.platform)
+			if err != nil {
+				t.Fatalf("newOciLayoutBackend() error = %v", err)
+			}
+			imageList, err := cli.ImageList(context.Background(), image.ListOptions{})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ImageList() error = %v, want error %t", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(imageList) != len(test.images) {
+				t.Fatalf("ImageList() listed %d images, want %d", len(imageList), len(test.images))
+			}
+			for _, id := range test.images {
+				if _, _, err := cli.ImageInspectWithRaw(context.Background(), id); err != nil {
+					t.Errorf("ImageInspectWithRaw(%s) error = %v", id, err)
+				}
+			}
+		})
+	}
+}
+
+func TestOciLayoutBackendNames(t *testing.T) {
+	ctx := context.Background()
+	layoutPath, configs := writeTestOciLayout(t)
+	cli, err := newOciLayoutBackend(layoutPath, v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"})
+	if err != nil {
+		t.Fatalf("newOciLayoutBackend() error = %v", err)
+	}
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, "registry.example.com/app:1.0")
+	if err != nil {
+		t.Fatalf("ImageInspectWithRaw() error = %v", err)
+	}
+	if inspect.ID != configs["linux/arm64/v8"] || inspect.Architecture != "arm64" || inspect.Variant != "v8" {
+		t.Errorf("ImageInspectWithRaw() = %s %s/%s, want the arm64/v8 image", inspect.ID, inspect.Architecture, inspect.Variant)
+	}
+	if len(inspect.RepoDigests) != 1 {
+		t.Errorf("ImageInspectWithRaw() repo digests = %v, want the digest of the index", inspect.RepoDigests)
+	}
+	imageHistory, err := cli.ImageHistory(ctx, "registry.example.com/app:1.0")
+	if err != nil || len(imageHistory) != 1 || imageHistory[0].CreatedBy != "RUN /bin/sh -c make linux/arm64/v8 # buildkit" {
+		t.Errorf("ImageHistory() = %v, %v, want the step of the arm64 image", imageHistory, err)
+	}
+	// A ref.name that is only a tag names no repository
+	inspect, _, err = cli.ImageInspectWithRaw(ctx, configs["tools"])
+	if err != nil || len(inspect.RepoTags) != 0 {
+		t.Errorf("ImageInspectWithRaw() tags = %v, %v, want none", inspect.RepoTags, err)
+	}
+}
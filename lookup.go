@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// OFFICIAL_BASES are the Docker Hub official images --lookup-bases tries first, the operating
+// system images most others are built on. Only these tags are compared, and most of them move to
+// every new release, so an image built on an older release matches none of them unless its tag is
+// in the base_candidates of the config file.
+var OFFICIAL_BASES = []string{
+	"alpine:latest",
+	"busybox:latest",
+	"debian:bookworm",
+	"debian:bookworm-slim",
+	"debian:bullseye",
+	"debian:bullseye-slim",
+	"ubuntu:24.04",
+	"ubuntu:22.04",
+	"ubuntu:20.04",
+	"amazonlinux:2023",
+	"amazonlinux:2",
+	"fedora:latest",
+	"rockylinux:9",
+}
+
+// registryBase is a candidate base image as the registry holds it for one platform.
+type registryBase struct {
+	name string
+	// digest is the digest of the manifest, which the FROM line can be pinned to
+	digest string
+	layers []string
+	// lastStep is the created_by of its newest history step, where the history of an image built
+	// on it is cut
+	lastStep string
+}
+
+// registryLookup finds base images that are not available locally in the registries, with
+// --lookup-bases. Every candidate is fetched once per platform and shared by all images.
+type registryLookup struct {
+	once       sync.Once
+	candidates []string
+	mirrors    map[string]string
+	keychain   authn.Keychain
+
+	mu    sync.Mutex
+	bases map[string]*registryBase
+}
+
+var REGISTRY_LOOKUP = &registryLookup{bases: map[string]*registryBase{}}
+
+// configure takes the base images of the config file, which are tried after the official ones.
+func (l *registryLookup) configure(opts *Options, config *Config) {
+	l.once.Do(func() {
+		l.candidates = append(slices.Clone(OFFICIAL_BASES), config.BaseCandidates...)
+		l.mirrors = config.mirrors
+		l.keychain = remoteKeychain(opts.Keychain)
+	})
+}
+
+// fetch returns a candidate for the platform, or nil when the registry does not have it.
+func (l *registryLookup) fetch(ctx context.Context, candidate string, platform v1.Platform) (base *registryBase) {
+	key := candidate + " " + platform.String()
+	l.mu.Lock()
+	base, ok := l.bases[key]
+	l.mu.Unlock()
+	if ok {
+		return base
+	}
+
+	// A candidate that cannot be fetched is not tried again
+	defer func() {
+		if ctx.Err() == nil {
+			l.mu.Lock()
+			l.bases[key] = base
+			l.mu.Unlock()
+		}
+	}()
+	ref, err := name.ParseReference(candidate)
+	if err != nil {
+		return nil
+	}
+	mirrored, err := mirrorReference(ref, l.mirrors)
+	if err != nil {
+		return nil
+	}
+	descriptor, err := remote.Get(mirrored, remote.WithContext(ctx), remote.WithPlatform(platform), remote.WithAuthFromKeychain(l.keychain))
+	if err != nil {
+		return nil
+	}
+	img, err := descriptor.Image()
+	if err != nil {
+		return nil
+	}
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		return nil
+	}
+	var config ocispec.Image
+	if json.Unmarshal(rawConfig, &config) != nil {
+		return nil
+	}
+	base = &registryBase{name: candidate, digest: descriptor.Digest.String()}
+	for _, layer := range config.RootFS.DiffIDs {
+		base.layers = append(base.layers, layer.String())
+	}
+	if len(config.History) > 0 {
+		base.lastStep = config.History[len(config.History)-1].CreatedBy
+	}
+	return base
+}
+
+// lookup tries the candidates in order and returns the one whose layers start the image with the
+// most layers. Candidates the registry does not have for the platform are skipped.
+func (l *registryLookup) lookup(ctx context.Context, opts *Options, config *Config, layers []string, platform v1.Platform) (found *registryBase, err error) {
+	l.configure(opts, config)
+	defer PROGRESS.clear()
+	for i, candidate := range l.candidates {
+		PROGRESS.update("looking up the base image in the registries %d/%d", i+1, len(l.candidates))
+		base := l.fetch(ctx, candidate, platform)
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		if base == nil || len(base.layers) == 0 || len(base.layers) > len(layers) || !slices.Equal(base.layers, layers[:len(base.layers)]) {
+			continue
+		}
+		if found == nil || len(base.layers) > len(found.layers) {
+			found = base
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+type matrixCommand struct {
+	Args struct {
+		Images []string `positional-arg-name:"image" description:"The images to compare, at least two." required:"2"`
+	} `positional-args:"yes" required:"yes"`
+	opts *Options
+}
+
+// matrixImage is what one image of the matrix is compared by.
+type matrixImage struct {
+	name         string
+	id           string
+	layers       []string
+	instructions []string
+	result       extraction
+}
+
+// similarity compares two images of the matrix.
+type similarity struct {
+	ImageA string `json:"image_a"`
+	ImageB string `json:"image_b"`
+	// SharedLayers is the number of layers the two images start with in common
+	SharedLayers int `json:"shared_layers"`
+	// IdenticalInstructions is the percentage of the instructions of both images found in the other one
+	IdenticalInstructions int `json:"identical_instructions_percent"`
+}
+
+// compareImages counts the layers two images share, which can only be a common chain from the
+// bottom, and the share of instructions that appear in both, each one matched at most once.
+func compareImages(a matrixImage, b matrixImage) (result similarity) {
+	result = similarity{ImageA: a.name, ImageB: b.name}
+	for result.SharedLayers < min(len(a.layers), len(b.layers)) && a.layers[result.SharedLayers] == b.layers[result.SharedLayers] {
+		result.SharedLayers++
+	}
+	unmatched := map[string]int{}
+	for _, instruction := range b.instructions {
+		unmatched[instruction]++
+	}
+	var identical int
+	for _, instruction := range a.instructions {
+		if unmatched[instruction] > 0 {
+			unmatched[instruction]--
+			identical++
+		}
+	}
+	if total := len(a.instructions) + len(b.instructions); total > 0 {
+		result.IdenticalInstructions = 200 * identical / total
+	}
+	return result
+}
+
+// Execute reconstructs every image at the same time, sharing the layer index, and prints the
+// similarity of every pair.
+func (c *matrixCommand) Execute(args []string) (err error) {
+	ctx, cancel := newContext(c.opts)
+	defer cancel()
+
+	config, err := loadConfig(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+	// Remote mode only fetches the images it is given
+	c.opts.ImageNames = c.Args.Images
+	cli, err := newBackend(c.opts, config)
+	if err != nil {
+		return err
+	}
+	imageList, err := cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to generate the list of images: %w", err)
+	}
+
+	var targets []imageTarget
+	for _, imageName := range c.Args.Images {
+		targets = append(targets, namedTarget(imageName))
+	}
+	images, errs := reconstructImages(ctx, cli, imageList, targets, len(targets), c.opts, config)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	var pairs []similarity
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			pairs = append(pairs, compareImages(images[i], images[j]))
+		}
+	}
+	if c.opts.Format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(pairs)
+	}
+	return printMatrix(os.Stdout, images)
+}
+
+// reconstructImages reconstructs the targets on up to concurrency workers sharing one layer index.
+// The images and errors are in the order of the targets.
+func reconstructImages(ctx context.Context, cli imageBackend, imageList []image.Summary, targets []imageTarget, concurrency int, opts *Options, config *Config) (images []matrixImage, errs []error) {
+	var layers layerIndex
+	images = make([]matrixImage, len(targets))
+	errs = make([]error, len(targets))
+	slots := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			images[i], errs[i] = reconstructImage(ctx, cli, imageList, &layers, target, opts, config)
+		}()
+	}
+	wg.Wait()
+	return images, errs
+}
+
+func reconstructImage(ctx context.Context, cli imageBackend, imageList []image.Summary, layers *layerIndex, target imageTarget, opts *Options, config *Config) (img matrixImage, err error) {
+	myImage, err := findImageFromImageList(imageList, target.imageId, target.repoTag)
+	if err != nil {
+		return img, err
+	}
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, myImage.ID)
+	if err != nil {
+		return img, fmt.Errorf("unable to inspect the image %s: %w", myImage.ID, err)
+	}
+	result, err := extractDockerfile(ctx, cli, imageList, layers, myImage, target.repoTag, opts, config)
+	if err != nil {
+		return img, err
+	}
+	return matrixImage{name: target.repoTag, id: myImage.ID, layers: inspect.RootFS.Layers, instructions: result.Instructions, result: result}, nil
+}
+
+// printMatrix prints the images as both the rows and the numbered columns of a table, each cell
+// holding the shared layers and the percentage of identical instructions of a pair.
+func printMatrix(w io.Writer, images []matrixImage) (err error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	header := []string{"IMAGE"}
+	for i := range images {
+		header = append(header, fmt.Sprintf("[%d]", i+1))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for i, a := range images {
+		row := []string{fmt.Sprintf("[%d] %s", i+1, a.name)}
+		for j, b := range images {
+			if i == j {
+				row = append(row, "-")
+				continue
+			}
+			pair := compareImages(a, b)
+			row = append(row, fmt.Sprintf("%d layers, %d%%", pair.SharedLayers, pair.IdenticalInstructions))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
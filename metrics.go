@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+)
+
+type metricsCommand struct {
+	Once   bool   `long:"once" description:"Print the metrics once, e.g. for the node_exporter textfile collector, instead of serving them."`
+	Listen string `long:"listen" description:"Serve the metrics at /metrics on this address." default:":9781"`
+	opts   *Options
+}
+
+// imageMetrics is what is exported about one image. An image that cannot be reconstructed only
+// has the metrics of the image list.
+type imageMetrics struct {
+	image         string
+	imageId       string
+	size          int64
+	created       time.Time
+	reconstructed bool
+	layers        int
+	baseImage     string
+	findings      int
+}
+
+// metricsCollector reconstructs the images on every collection, sharing the backend, whose cache
+// spares the daemon from inspecting unchanged images again.
+type metricsCollector struct {
+	mu     sync.Mutex
+	cli    imageBackend
+	policy *policy
+	base   *baseline
+	opts   *Options
+	config *Config
+}
+
+func (c *metricsCollector) collect(ctx context.Context) (metrics []imageMetrics, err error) {
+	// Scrapes that overlap would reconstruct the same images twice
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	imageList, err := c.cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate the list of images: %w", err)
+	}
+	var targets []imageTarget
+	if len(c.opts.Match) > 0 || len(c.opts.MatchRe) > 0 {
+		targets = matchingTargets(imageList, c.opts.Match, c.opts.MatchRe)
+	} else {
+		for _, img := range imageList {
+			targets = append(targets, listedTarget(img))
+		}
+	}
+	slices.SortStableFunc(targets, func(a, b imageTarget) int {
+		return strings.Compare(a.repoTag, b.repoTag)
+	})
+
+	images, errs := reconstructImages(ctx, c.cli, imageList, targets, c.opts.Concurrency, c.opts, c.config)
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	for i, target := range targets {
+		listed, err := findImageFromImageList(imageList, target.imageId, target.repoTag)
+		if err != nil {
+			return nil, err
+		}
+		m := imageMetrics{image: target.repoTag, imageId: listed.ID, size: listed.Size}
+		if listed.Created > 0 {
+			m.created = time.Unix(listed.Created, 0)
+		}
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "warning: %s: %s\n", target.repoTag, errs[i])
+			metrics = append(metrics, m)
+			continue
+		}
+		m.reconstructed = true
+		m.layers = len(images[i].layers)
+		m.baseImage = images[i].result.BaseImage
+		if c.policy != nil {
+			findings, _ := c.base.apply(c.policy.evaluate(images[i].result))
+			m.findings = len(findings)
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// baseFamily is the repository of a base image without its tag or digest, e.g. alpine or
+// gcr.io/distroless/static, so that images built on different versions of a base are grouped.
+func baseFamily(baseImage string) (family string) {
+	if baseImage == "" {
+		return ""
+	}
+	named, err := reference.ParseNormalizedNamed(baseImage)
+	if err != nil {
+		return baseImage
+	}
+	return reference.FamiliarName(named)
+}
+
+var METRIC_LABEL_ESCAPER = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricLabels formats label pairs, given as name and value in turn, for the Prometheus text format.
+func metricLabels(pairs ...string) (labels string) {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], METRIC_LABEL_ESCAPER.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// writeMetrics writes the metrics in the Prometheus text exposition format, one gauge family at a
+// time. The findings are only exported when a policy is in use.
+func writeMetrics(w io.Writer, metrics []imageMetrics, withFindings bool, now time.Time) (err error) {
+	type gauge struct {
+		name  string
+		help  string
+		value func(m imageMetrics) (labels string, value float64, ok bool)
+	}
+	gauges := []gauge{
+		{"dfimage_image_size_bytes", "Size of the image, including the layers it shares with other images.", func(m imageMetrics) (string, float64, bool) {
+			return metricLabels("image", m.image, "image_id", m.imageId), float64(m.size), true
+		}},
+		{"dfimage_image_layers", "Number of layers of the image.", func(m imageMetrics) (string, float64, bool) {
+			return metricLabels("image", m.image), float64(m.layers), m.reconstructed
+		}},
+		{"dfimage_image_age_seconds", "Seconds since the image was created.", func(m imageMetrics) (string, float64, bool) {
+			return metricLabels("image", m.image), now.Sub(m.created).Truncate(time.Second).Seconds(), !m.created.IsZero()
+		}},
+		{"dfimage_image_base_info", "Base image of the image and its family, the base repository without a tag. Always 1.", func(m imageMetrics) (string, float64, bool) {
+			return metricLabels("image", m.image, "base_image", m.baseImage, "base_family", baseFamily(m.baseImage)), 1, m.reconstructed && m.baseImage != ""
+		}},
+		{"dfimage_image_reconstructed", "Whether the Dockerfile of the image could be reconstructed, 1 or 0.", func(m imageMetrics) (string, float64, bool) {
+			value := 0.0
+			if m.reconstructed {
+				value = 1
+			}
+			return metricLabels("image", m.image), value, true
+		}},
+	}
+	if withFindings {
+		gauges = append(gauges, gauge{"dfimage_image_policy_findings", "Number of policy findings of the image, after the baseline.", func(m imageMetrics) (string, float64, bool) {
+			return metricLabels("image", m.image), float64(m.findings), m.reconstructed
+		}})
+	}
+
+	var buf bytes.Buffer
+	for _, g := range gauges {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, m := range metrics {
+			if labels, value, ok := g.value(m); ok {
+				fmt.Fprintf(&buf, "%s%s %s\n", g.name, labels, strconv.FormatFloat(value, 'f', -1, 64))
+			}
+		}
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// Execute prints the metrics of the images once or serves them to Prometheus, reconstructing the
+// images on every scrape.
+func (c *metricsCommand) Execute(args []string) (err error) {
+	err = requireFeature(c.opts, FEATURE_LIST, "metrics describes every image of a daemon or an archive")
+	if err != nil {
+		return err
+	}
+	if len(c.opts.ImageNames) > 0 || len(args) > 0 {
+		return fmt.Errorf("metrics describes every image - select them with --match or --match-re instead")
+	}
+	for _, expr := range c.opts.MatchRe {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("--match-re %s is not a valid regular expression: %w", expr, err)
+		}
+	}
+
+	config, err := loadConfig(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+	p, base, err := loadPolicyOptions(c.opts)
+	if err != nil {
+		return err
+	}
+	cli, err := newBackend(c.opts, config)
+	if err != nil {
+		return err
+	}
+	collector := &metricsCollector{cli: cli, policy: p, base: base, opts: c.opts, config: config}
+
+	if c.Once {
+		ctx, cancel := newContext(c.opts)
+		defer cancel()
+		metrics, err := collector.collect(ctx)
+		if err != nil {
+			return err
+		}
+		return writeMetrics(os.Stdout, metrics, p != nil, time.Now())
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		// --timeout bounds every scrape rather than the server
+		ctx, cancel := newContext(c.opts)
+		defer cancel()
+		metrics, err := collector.collect(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: unable to collect the metrics: %s\n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, metrics, p != nil, time.Now())
+	})
+	server := &http.Server{Addr: c.Listen, Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	fmt.Fprintf(os.Stderr, "Serving the metrics at http://%s/metrics.\n", c.Listen)
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("unable to serve the metrics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ociImage is an image known only by its OCI config, as containerd, the CRI, registries and image
+// archives provide it.
+type ociImage struct {
+	summary  image.Summary
+	config   dockerImage
+	manifest manifestDetails
+}
+
+// dockerImage is an OCI image config with the fields Docker adds to it, which the configs of
+// docker save, BuildKit and the registries carry for images built with SHELL or HEALTHCHECK.
+type dockerImage struct {
+	ocispec.Image
+	Config dockerImageConfig `json:"config,omitempty"`
+}
+
+type dockerImageConfig struct {
+	ocispec.ImageConfig
+	Shell       []string                `json:"Shell,omitempty"`
+	Healthcheck *container.HealthConfig `json:"Healthcheck,omitempty"`
+}
+
+// isForeignLayer reports whether a layer media type marks content that registries do not
+// distribute, such as the Windows base layers pulled from Microsoft.
+func isForeignLayer(mediaType string) bool {
+	return mediaType == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip" ||
+		strings.HasPrefix(mediaType, "application/vnd.oci.image.layer.nondistributable.")
+}
+
+// ociImageIndex holds the images of a backend without a docker API, converted on request into the
+// types the docker daemon would return. All names pointing at the same config are folded into one
+// image, the way the docker daemon lists them.
+type ociImageIndex struct {
+	images map[string]*ociImage
+	names  map[string]string
+}
+
+func newOciImageIndex() (index *ociImageIndex) {
+	return &ociImageIndex{images: map[string]*ociImage{}, names: map[string]string{}}
+}
+
+// add records an image config under its ID, unless it is already known.
+func (index *ociImageIndex) add(id string, config dockerImage) (entry *ociImage) {
+	if entry, ok := index.images[id]; ok {
+		return entry
+	}
+	entry = &ociImage{summary: image.Summary{ID: id, Labels: config.Config.Labels}, config: config}
+	if config.Created != nil {
+		entry.summary.Created = config.Created.Unix()
+	}
+	index.images[id] = entry
+	return entry
+}
+
+// addName records a name of the image with the given ID. A tagged name also adds a repo digest when
+// the digest of the manifest it points at is known.
+func (index *ociImageIndex) addName(id string, name string, manifestDigest string) {
+	entry, ok := index.images[id]
+	// Kubernetes keeps an extra name for every image that is just its ID
+	if !ok || strings.HasPrefix(name, "sha256:") {
+		return
+	}
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return
+	}
+	index.names[normalizeImageName(name)] = id
+	if canonical, isDigest := named.(reference.Canonical); isDigest {
+		entry.summary.RepoDigests = append(entry.summary.RepoDigests, reference.FamiliarName(named)+"@"+canonical.Digest().String())
+		return
+	}
+	entry.summary.RepoTags = append(entry.summary.RepoTags, reference.FamiliarString(named))
+	if manifestDigest != "" {
+		entry.summary.RepoDigests = append(entry.summary.RepoDigests, reference.FamiliarName(named)+"@"+manifestDigest)
+	}
+}
+
+// sortNames orders the names of every image, once all of them have been added.
+func (index *ociImageIndex) sortNames() {
+	for _, entry := range index.images {
+		slices.Sort(entry.summary.RepoTags)
+		entry.summary.RepoTags = slices.Compact(entry.summary.RepoTags)
+		slices.Sort(entry.summary.RepoDigests)
+		entry.summary.RepoDigests = slices.Compact(entry.summary.RepoDigests)
+	}
+}
+
+// find looks an image up by ID or by name.
+func (index *ociImageIndex) find(imageId string) (entry *ociImage, ok bool) {
+	if entry, ok := index.images[imageId]; ok {
+		return entry, true
+	}
+	if id, ok := index.names[normalizeImageName(imageId)]; ok {
+		return index.images[id], true
+	}
+	return nil, false
+}
+
+// list returns the images newest first, like the docker daemon.
+func (index *ociImageIndex) list() (imageList []image.Summary) {
+	for _, entry := range index.images {
+		imageList = append(imageList, entry.summary)
+	}
+	slices.SortFunc(imageList, func(a, b image.Summary) int {
+		return cmp.Or(cmp.Compare(b.Created, a.Created), strings.Compare(a.ID, b.ID))
+	})
+	return imageList
+}
+
+// indexBackend answers the docker API calls from an ociImageIndex, which load fills on first use.
+type indexBackend struct {
+	// source describes where the images come from, for error messages
+	source string
+	load   func(ctx context.Context) (*ociImageIndex, error)
+	index  *ociImageIndex
+}
+
+func (c *indexBackend) loaded(ctx context.Context) (index *ociImageIndex, err error) {
+	if c.index == nil {
+		c.index, err = c.load(ctx)
+	}
+	return c.index, err
+}
+
+func (c *indexBackend) find(ctx context.Context, imageId string) (entry *ociImage, err error) {
+	index, err := c.loaded(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := index.find(imageId)
+	if !ok {
+		return nil, fmt.Errorf("the image %s was not found in %s", imageId, c.source)
+	}
+	return entry, nil
+}
+
+func (c *indexBackend) ImageList(ctx context.Context, options image.ListOptions) (imageList []image.Summary, err error) {
+	index, err := c.loaded(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return index.list(), nil
+}
+
+func (c *indexBackend) ImageInspectWithRaw(ctx context.Context, imageId string) (inspect types.ImageInspect, raw []byte, err error) {
+	entry, err := c.find(ctx, imageId)
+	if err != nil {
+		return inspect, nil, err
+	}
+	return entry.inspect()
+}
+
+// ManifestDetails returns what the manifest of the image tells. The sources that do not read
+// manifests, such as the CRI, leave them empty.
+func (c *indexBackend) ManifestDetails(ctx context.Context, imageId string) (details manifestDetails, err error) {
+	entry, err := c.find(ctx, imageId)
+	if err != nil {
+		return details, err
+	}
+	return entry.manifest, nil
+}
+
+func (c *indexBackend) ImageHistory(ctx context.Context, imageId string) (imageHistory []image.HistoryResponseItem, err error) {
+	entry, err := c.find(ctx, imageId)
+	if err != nil {
+		return nil, err
+	}
+	return entry.history(), nil
+}
+
+func (entry *ociImage) inspect() (inspect types.ImageInspect, raw []byte, err error) {
+	config := entry.config
+	inspect = types.ImageInspect{
+		ID:           entry.summary.ID,
+		RepoTags:     entry.summary.RepoTags,
+		RepoDigests:  entry.summary.RepoDigests,
+		Author:       config.Author,
+		Os:           config.OS,
+		Architecture: config.Architecture,
+		Variant:      config.Variant,
+		RootFS:       types.RootFS{Type: config.RootFS.Type},
+		Config: &container.Config{
+			User:        config.Config.User,
+			Env:         config.Config.Env,
+			Entrypoint:  config.Config.Entrypoint,
+			Cmd:         config.Config.Cmd,
+			WorkingDir:  config.Config.WorkingDir,
+			Labels:      config.Config.Labels,
+			StopSignal:  config.Config.StopSignal,
+			Volumes:     config.Config.Volumes,
+			Shell:       config.Config.Shell,
+			Healthcheck: config.Config.Healthcheck,
+		},
+	}
+	if config.Created != nil {
+		inspect.Created = config.Created.Format(time.RFC3339Nano)
+	}
+	for _, diffId := range config.RootFS.DiffIDs {
+		inspect.RootFS.Layers = append(inspect.RootFS.Layers, diffId.String())
+	}
+	if len(config.Config.ExposedPorts) > 0 {
+		inspect.Config.ExposedPorts = nat.PortSet{}
+		for port := range config.Config.ExposedPorts {
+			inspect.Config.ExposedPorts[nat.Port(port)] = struct{}{}
+		}
+	}
+	raw, err = json.Marshal(inspect)
+	if err != nil {
+		return inspect, nil, fmt.Errorf("unable to encode the image %s: %w", entry.summary.ID, err)
+	}
+	return inspect, raw, nil
+}
+
+// history returns the history of the image config newest first, as the docker daemon does.
+func (entry *ociImage) history() (imageHistory []image.HistoryResponseItem) {
+	for i := len(entry.config.History) - 1; i >= 0; i-- {
+		event := entry.config.History[i]
+		item := image.HistoryResponseItem{
+			ID:        "<missing>",
+			CreatedBy: event.CreatedBy,
+			Comment:   event.Comment,
+		}
+		if event.Created != nil {
+			item.Created = event.Created.Unix()
+		}
+		imageHistory = append(imageHistory, item)
+	}
+	if len(imageHistory) > 0 {
+		imageHistory[0].ID = entry.summary.ID
+		imageHistory[0].Tags = entry.summary.RepoTags
+	}
+	return imageHistory
+}
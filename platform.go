@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/containerd/platforms"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// requestedPlatform is the platform picked from multi-platform images: the one given with
+// --platform or DOCKER_DEFAULT_PLATFORM, otherwise the one a docker daemon on this machine would pull.
+func requestedPlatform(opts *Options) (platform v1.Platform) {
+	if opts.Platform != "" {
+		if requested, err := platforms.Parse(opts.Platform); err == nil {
+			return v1.Platform{OS: requested.OS, Architecture: requested.Architecture, Variant: requested.Variant}
+		}
+	}
+	return v1.Platform{OS: "linux", Architecture: runtime.GOARCH}
+}
+
+// platformWarning explains when the image is built for another platform than the one requested with
+// --platform or DOCKER_DEFAULT_PLATFORM. The Dockerfile then describes an image that would not be
+// the one pulled.
+func platformWarning(result extraction, opts *Options) (warning string) {
+	if result.Architecture == "" || opts.Platform == "" {
+		return ""
+	}
+	want := requestedPlatform(opts)
+	got := v1.Platform{OS: result.OS, Architecture: result.Architecture, Variant: result.Variant}
+	if (got.OS == "" || got.OS == want.OS) && got.Architecture == want.Architecture && (want.Variant == "" || got.Variant == want.Variant) {
+		return ""
+	}
+	return fmt.Sprintf("the image is built for %s, not for the requested platform %s, so the Dockerfile describes the %s image", got.String(), want.String(), got.String())
+}
+
+// hostPlatformNote explains, without a requested platform, when the image is built for another CPU
+// architecture than this machine's. It depends on the machine, so it is only printed to STDERR and
+// the output stays the same on every host.
+func hostPlatformNote(result extraction, opts *Options) (note string) {
+	if result.Architecture == "" || opts.Platform != "" {
+		return ""
+	}
+	host := requestedPlatform(opts)
+	if result.Architecture == host.Architecture {
+		return ""
+	}
+	got := v1.Platform{OS: result.OS, Architecture: result.Architecture, Variant: result.Variant}
+	return fmt.Sprintf("the image is built for %s while this machine is %s, so the Dockerfile describes the %s image", got.String(), host.String(), got.String())
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/distribution/reference"
+	"gopkg.in/yaml.v3"
+)
+
+const EXIT_POLICY_FAILURE = 3
+
+// policy is a set of rules read from a --policy file.
+type policy struct {
+	Rules []policyRule `yaml:"rules"`
+}
+
+// policyRule checks every condition it sets. A rule without conditions always passes.
+type policyRule struct {
+	ID          string `yaml:"id"`
+	Description string `yaml:"description"`
+	Severity    string `yaml:"severity"`
+
+	// BaseRegistries lists the registries the FROM image may be pulled from, e.g. docker.io
+	BaseRegistries []string `yaml:"base_registries"`
+	// ForbidRootUser fails images whose final USER is root or unset
+	ForbidRootUser bool `yaml:"forbid_root_user"`
+	// DenyInstructions is a regular expression no instruction may match, e.g. ^ADD\s+https?://
+	DenyInstructions string `yaml:"deny_instructions"`
+	// RequireLabels lists labels the image must carry
+	RequireLabels []string `yaml:"require_labels"`
+	// AllowedBases and DeniedBases are patterns matched against the FROM image, e.g. alpine:3.* or
+	// registry.example.com/* (where * also matches /)
+	AllowedBases []string `yaml:"allowed_bases"`
+	DeniedBases  []string `yaml:"denied_bases"`
+
+	denyInstructions *regexp.Regexp
+}
+
+type finding struct {
+	RuleID      string `json:"rule_id"`
+	Severity    string `json:"severity"`
+	Message     string `json:"message"`
+	Instruction int    `json:"instruction,omitempty"`
+}
+
+func loadPolicy(path string) (p *policy, err error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the policy file: %w", err)
+	}
+	p = &policy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	err = decoder.Decode(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unable to parse the policy file %s: %w", path, err)
+	}
+
+	var ids []string
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.ID == "" {
+			return nil, fmt.Errorf("rule %d in the policy file %s has no id", i+1, path)
+		}
+		if slices.Contains(ids, rule.ID) {
+			return nil, fmt.Errorf("the policy file %s defines the rule %s more than once", path, rule.ID)
+		}
+		ids = append(ids, rule.ID)
+
+		switch rule.Severity {
+		case "":
+			rule.Severity = "error"
+		case "error", "warning":
+		default:
+			return nil, fmt.Errorf("rule %s has an unknown severity \"%s\" - use error or warning", rule.ID, rule.Severity)
+		}
+
+		if rule.DenyInstructions != "" {
+			rule.denyInstructions, err = regexp.Compile(rule.DenyInstructions)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s has an invalid deny_instructions pattern: %w", rule.ID, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// isRootUser reports whether a USER value runs as root. An unset user defaults to root.
+func isRootUser(user string) bool {
+	name, _, _ := strings.Cut(user, ":")
+	return name == "" || name == "root" || name == "0"
+}
+
+func imageRegistry(imageName string) (registry string, err error) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", err
+	}
+	return reference.Domain(named), nil
+}
+
+// readPatterns expands --allowed-bases and --denied-bases values. A value is a comma-separated list
+// of patterns, or @path to read one pattern per line from a file where # starts a comment.
+func readPatterns(values []string) (patterns []string, err error) {
+	for _, value := range values {
+		var candidates []string
+		if path, ok := strings.CutPrefix(value, "@"); ok {
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read the pattern file: %w", err)
+			}
+			for _, line := range strings.Split(string(contents), "\n") {
+				line, _, _ = strings.Cut(line, "#")
+				candidates = append(candidates, line)
+			}
+		} else {
+			candidates = strings.Split(value, ",")
+		}
+		for _, pattern := range candidates {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return patterns, nil
+}
+
+// matchImagePattern matches an image name against a glob pattern where * matches any run of
+// characters, including /. The name as written, its short form and its fully qualified form
+// are all tried, so alpine:* and docker.io/library/alpine:* both match alpine:3.19.
+func matchImagePattern(pattern string, imageName string) bool {
+	expr := "^" + strings.ReplaceAll(strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*"), `\?`, ".") + "$"
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return false
+	}
+	return matchImageRegexp(re, imageName)
+}
+
+// matchImageRegexp matches the image name as written, its short form and its fully qualified form
+// against re.
+func matchImageRegexp(re *regexp.Regexp, imageName string) bool {
+	names := []string{imageName}
+	if named, err := reference.ParseNormalizedNamed(imageName); err == nil {
+		names = append(names, reference.FamiliarString(named), named.String())
+	}
+	return slices.ContainsFunc(names, re.MatchString)
+}
+
+// withBaseLists adds rules for --allowed-bases and --denied-bases to p, creating p if needed.
+func (p *policy) withBaseLists(allowedBases []string, deniedBases []string, action string) (result *policy, err error) {
+	if p == nil {
+		p = &policy{}
+	}
+	severity := "error"
+	if action == "warn" {
+		severity = "warning"
+	}
+	allowed, err := readPatterns(allowedBases)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := readPatterns(deniedBases)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range []policyRule{
+		{ID: "allowed-bases", Severity: severity, AllowedBases: allowed},
+		{ID: "denied-bases", Severity: severity, DeniedBases: denied},
+	} {
+		if len(rule.AllowedBases) == 0 && len(rule.DeniedBases) == 0 {
+			continue
+		}
+		if slices.ContainsFunc(p.Rules, func(existing policyRule) bool { return existing.ID == rule.ID }) {
+			return nil, fmt.Errorf("the policy file already defines a rule named %s", rule.ID)
+		}
+		p.Rules = append(p.Rules, rule)
+	}
+	return p, nil
+}
+
+func (rule policyRule) evaluate(result extraction) (findings []finding) {
+	fail := func(instruction int, format string, a ...any) {
+		findings = append(findings, finding{
+			RuleID:      rule.ID,
+			Severity:    rule.Severity,
+			Message:     fmt.Sprintf(format, a...),
+			Instruction: instruction,
+		})
+	}
+
+	if len(rule.BaseRegistries) > 0 {
+		if result.BaseImage == "" {
+			fail(1, "the base image could not be determined, so its registry cannot be checked")
+		} else if result.BaseImage == SCRATCH {
+			// scratch is empty and is not pulled from any registry
+		} else if registry, err := imageRegistry(result.BaseImage); err != nil {
+			fail(1, "the base image %s is not a valid reference: %s", result.BaseImage, err)
+		} else if !slices.Contains(rule.BaseRegistries, registry) {
+			fail(1, "the base image %s comes from %s, which is not one of %s", result.BaseImage, registry, strings.Join(rule.BaseRegistries, ", "))
+		}
+	}
+
+	if rule.ForbidRootUser && isRootUser(result.Config.User) {
+		if result.Config.User == "" {
+			fail(lastInstruction(result.Instructions, "USER"), "the image runs as root because no USER is set")
+		} else {
+			fail(lastInstruction(result.Instructions, "USER"), "the image runs as root (USER %s)", result.Config.User)
+		}
+	}
+
+	if rule.denyInstructions != nil {
+		for i, instruction := range result.Instructions {
+			if rule.denyInstructions.MatchString(instruction) {
+				fail(i+1, "instruction %d matches the denied pattern %s", i+1, rule.DenyInstructions)
+			}
+		}
+	}
+
+	if len(rule.AllowedBases) > 0 {
+		if result.BaseImage == "" {
+			fail(1, "the base image could not be determined, so it cannot be checked against the allowed bases")
+		} else if !slices.ContainsFunc(rule.AllowedBases, func(pattern string) bool { return matchImagePattern(pattern, result.BaseImage) }) {
+			fail(1, "the base image %s does not match any of the allowed bases %s", result.BaseImage, strings.Join(rule.AllowedBases, ", "))
+		}
+	}
+
+	if result.BaseImage != "" {
+		for _, pattern := range rule.DeniedBases {
+			if matchImagePattern(pattern, result.BaseImage) {
+				fail(1, "the base image %s matches the denied base %s", result.BaseImage, pattern)
+			}
+		}
+	}
+
+	for _, label := range rule.RequireLabels {
+		if _, ok := result.Config.Labels[label]; !ok {
+			fail(lastInstruction(result.Instructions, "LABEL"), "the required label %s is missing", label)
+		}
+	}
+	return findings
+}
+
+// lastInstruction numbers the last instruction with the keyword, such as USER, which a finding
+// about what it sets points at, or else the last instruction, after which it would be added.
+func lastInstruction(instructions []string, keyword string) (instruction int) {
+	for i := len(instructions) - 1; i >= 0; i-- {
+		if strings.HasPrefix(instructions[i], keyword+" ") {
+			return i + 1
+		}
+	}
+	return len(instructions)
+}
+
+// loadPolicyOptions loads the policy of --policy, --allowed-bases and --denied-bases, and the
+// --baseline, each of which is nil when not given.
+func loadPolicyOptions(opts *Options) (p *policy, base *baseline, err error) {
+	if opts.PolicyFile != "" {
+		p, err = loadPolicy(opts.PolicyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(opts.AllowedBases) > 0 || len(opts.DeniedBases) > 0 {
+		p, err = p.withBaseLists(opts.AllowedBases, opts.DeniedBases, opts.BasesAction)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts.Baseline != "" {
+		base, err = loadBaseline(opts.Baseline)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return p, base, nil
+}
+
+func (p *policy) evaluate(result extraction) (findings []finding) {
+	for _, rule := range p.Rules {
+		findings = append(findings, rule.evaluate(result)...)
+	}
+	return findings
+}
+
+// printPolicyReport writes one PASS, WARN or FAIL line per rule followed by a summary, and reports
+// whether any finding with error severity remains.
+func printPolicyReport(w io.Writer, p *policy, findings []finding, suppressed int) (failed bool) {
+	var failedRules int
+	for _, rule := range p.Rules {
+		var ruleFindings []finding
+		for _, f := range findings {
+			if f.RuleID == rule.ID {
+				ruleFindings = append(ruleFindings, f)
+			}
+		}
+		if len(ruleFindings) == 0 {
+			fmt.Fprintf(w, "PASS %s\n", rule.ID)
+			continue
+		}
+		var ruleFailed bool
+		for _, f := range ruleFindings {
+			status := "WARN"
+			if f.Severity == "error" {
+				status = "FAIL"
+				ruleFailed = true
+			}
+			fmt.Fprintf(w, "%s %s: %s\n", status, rule.ID, f.Message)
+		}
+		if ruleFailed {
+			failed = true
+			failedRules++
+		}
+	}
+	fmt.Fprintf(w, "policy: %d of %d rules failed", failedRules, len(p.Rules))
+	if suppressed > 0 {
+		fmt.Fprintf(w, ", %d findings suppressed by the baseline", suppressed)
+	}
+	fmt.Fprintln(w)
+	return failed
+}
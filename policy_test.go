@@ -0,0 +1,215 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPolicyRuleEvaluate(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      policyRule
+		baseImage string
+		labels    map[string]string
+		// messages are the findings the rule reports
+		messages []string
+	}{
+		{
+			name:      "an allowed base",
+			rule:      policyRule{AllowedBases: []string{"alpine:3.*", "registry.example.com/*"}},
+			baseImage: "alpine:3.20",
+		},
+		{
+			name:      "an allowed base by its fully qualified name",
+			rule:      policyRule{AllowedBases: []string{"docker.io/library/alpine:*"}},
+			baseImage: "alpine:3.20",
+		},
+		{
+			name:      "an allowed base under a registry prefix",
+			rule:      policyRule{AllowedBases: []string{"registry.example.com/*"}},
+			baseImage: "registry.example.com/platform/python:3.12",
+		},
+		{
+			name:      "a base outside the allowed bases",
+			rule:      policyRule{AllowedBases: []string{"alpine:3.*"}},
+			baseImage: "debian:bookworm",
+			messages:  []string{"the base image debian:bookworm does not match any of the allowed bases alpine:3.*"},
+		},
+		{
+			name:     "an unknown base with allowed bases",
+			rule:     policyRule{AllowedBases: []string{"alpine:3.*"}},
+			messages: []string{"the base image could not be determined, so it cannot be checked against the allowed bases"},
+		},
+		{
+			name:      "a denied base",
+			rule:      policyRule{DeniedBases: []string{"*:latest", "centos:*"}},
+			baseImage: "centos:7",
+			messages:  []string{"the base image centos:7 matches the denied base centos:*"},
+		},
+		{
+			name:      "a base that is not denied",
+			rule:      policyRule{DeniedBases: []string{"*:latest", "centos:*"}},
+			baseImage: "alpine:3.20",
+		},
+		{
+			name: "an unknown base with denied bases",
+			rule: policyRule{DeniedBases: []string{"centos:*"}},
+		},
+		{
+			name:      "an allowed registry",
+			rule:      policyRule{BaseRegistries: []string{"docker.io"}},
+			baseImage: "alpine:3.20",
+		},
+		{
+			name:      "a registry that is not allowed",
+			rule:      policyRule{BaseRegistries: []string{"docker.io"}},
+			baseImage: "quay.io/centos/centos:stream9",
+			messages:  []string{"the base image quay.io/centos/centos:stream9 comes from quay.io, which is not one of docker.io"},
+		},
+		{
+			name:      "scratch with allowed registries",
+			rule:      policyRule{BaseRegistries: []string{"docker.io"}},
+			baseImage: SCRATCH,
+		},
+		{
+			name:     "an unknown base with allowed registries",
+			rule:     policyRule{BaseRegistries: []string{"docker.io"}},
+			messages: []string{"the base image could not be determined, so its registry cannot be checked"},
+		},
+		{
+			name:   "the required labels",
+			rule:   policyRule{RequireLabels: []string{"org.opencontainers.image.source", "org.opencontainers.image.version"}},
+			labels: map[string]string{"org.opencontainers.image.source": "https://example.com/app", "org.opencontainers.image.version": ""},
+		},
+		{
+			name:     "a missing required label",
+			rule:     policyRule{RequireLabels: []string{"org.opencontainers.image.source", "org.opencontainers.image.version"}},
+			labels:   map[string]string{"org.opencontainers.image.version": "1.0"},
+			messages: []string{"the required label org.opencontainers.image.source is missing"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := extraction{BaseImage: test.baseImage, Instructions: []string{"FROM " + test.baseImage}}
+			result.Config.User = "app"
+			result.Config.Labels = test.labels
+			var messages []string
+			for _, f := range test.rule.evaluate(result) {
+				messages = append(messages, f.Message)
+			}
+			if !slices.Equal(messages, test.messages) {
+				t.Errorf("evaluate() = %q, want %q", messages, test.messages)
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{
+		{
+			name: "distinct rules",
+			policy: `rules:
+  - id: allowed-bases
+    allowed_bases: ["alpine:3.*"]
+  - id: source-label
+    require_labels: [org.opencontainers.image.source]
+`,
+		},
+		{
+			name: "a duplicate rule",
+			policy: `rules:
+  - id: non-root
+    forbid_root_user: true
+  - id: non-root
+    severity: warning
+`,
+			wantErr: "defines the rule non-root more than once",
+		},
+		{
+			name: "a rule without an id",
+			policy: `rules:
+  - forbid_root_user: true
+`,
+			wantErr: "rule 1 in the policy file",
+		},
+		{
+			name: "an unknown severity",
+			policy: `rules:
+  - id: non-root
+    severity: fatal
+`,
+			wantErr: `unknown severity "fatal"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.yaml")
+			err := os.WriteFile(path, []byte(test.policy), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = loadPolicy(path)
+			if test.wantErr == "" && err != nil {
+				t.Errorf("loadPolicy() error = %v", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("loadPolicy() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithBaseLists(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *policy
+		allowed []string
+		denied  []string
+		rules   []string
+		wantErr bool
+	}{
+		{
+			name:    "without a policy file",
+			allowed: []string{"alpine:3.*,debian:*"},
+			denied:  []string{"*:latest"},
+			rules:   []string{"allowed-bases", "denied-bases"},
+		},
+		{
+			name:   "added to a policy file",
+			policy: &policy{Rules: []policyRule{{ID: "non-root", ForbidRootUser: true}}},
+			denied: []string{"*:latest"},
+			rules:  []string{"non-root", "denied-bases"},
+		},
+		{
+			name:    "a policy file that already defines the rule",
+			policy:  &policy{Rules: []policyRule{{ID: "allowed-bases"}}},
+			allowed: []string{"alpine:3.*"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := test.policy.withBaseLists(test.allowed, test.denied, "fail")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("withBaseLists() error = %v, want error %t", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var rules []string
+			for _, rule := range p.Rules {
+				rules = append(rules, rule.ID)
+			}
+			if !slices.Equal(rules, test.rules) {
+				t.Errorf("withBaseLists() rules = %q, want %q", rules, test.rules)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+// DFIMAGE_PPROF names a directory to write both profiles to, as dfimage-cpu.pprof and
+// dfimage-mem.pprof, for when the hidden flags cannot easily be passed, e.g. in a CI job.
+const DFIMAGE_PPROF = "DFIMAGE_PPROF"
+
+// profiling writes the Go pprof profiles asked for with --cpuprofile, --memprofile or
+// DFIMAGE_PPROF.
+type profiling struct {
+	once       sync.Once
+	cpuFile    *os.File
+	memProfile string
+}
+
+// PROFILING is stopped on every way out of main, as os.Exit skips deferred calls.
+var PROFILING = &profiling{}
+
+// start starts the CPU profile, if one is asked for.
+func (p *profiling) start(opts *Options) (err error) {
+	cpuProfile, memProfile := opts.CpuProfile, opts.MemProfile
+	if dir := os.Getenv(DFIMAGE_PPROF); dir != "" {
+		if cpuProfile == "" {
+			cpuProfile = filepath.Join(dir, "dfimage-cpu.pprof")
+		}
+		if memProfile == "" {
+			memProfile = filepath.Join(dir, "dfimage-mem.pprof")
+		}
+	}
+	p.memProfile = memProfile
+
+	if cpuProfile == "" {
+		return nil
+	}
+	cpuFile, err := os.Create(cpuProfile)
+	if err != nil {
+		return fmt.Errorf("unable to create the CPU profile: %w", err)
+	}
+	err = pprof.StartCPUProfile(cpuFile)
+	if err != nil {
+		cpuFile.Close()
+		return fmt.Errorf("unable to start the CPU profile: %w", err)
+	}
+	p.cpuFile = cpuFile
+	return nil
+}
+
+// stop ends the CPU profile and writes the heap profile. Only the first call does anything.
+func (p *profiling) stop() {
+	p.once.Do(func() {
+		if p.cpuFile != nil {
+			pprof.StopCPUProfile()
+			p.cpuFile.Close()
+		}
+		if p.memProfile != "" {
+			err := writeHeapProfile(p.memProfile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+			}
+		}
+	})
+}
+
+func writeHeapProfile(path string) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create the memory profile: %w", err)
+	}
+	defer f.Close()
+	// Collect garbage first so the profile shows what is still in use
+	runtime.GC()
+	err = pprof.WriteHeapProfile(f)
+	if err != nil {
+		return fmt.Errorf("unable to write the memory profile: %w", err)
+	}
+	return nil
+}
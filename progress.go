@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// progress draws a status line such as "inspecting image 142/600" on STDERR during long scans. It
+// is only drawn when STDOUT and STDERR are both terminals, so redirected output and logs never
+// contain it.
+type progress struct {
+	mu      sync.Mutex
+	enabled bool
+	drawn   bool
+}
+
+// PROGRESS is shared by everything that scans, as there is a single status line.
+var PROGRESS = &progress{enabled: isTerminal(os.Stdout) && isTerminal(os.Stderr)}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// update replaces the status line.
+func (p *progress) update(format string, args ...any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.enabled {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K"+format, args...)
+	p.drawn = true
+}
+
+// clear removes the status line, if one is drawn.
+func (p *progress) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.erase()
+}
+
+func (p *progress) erase() {
+	if p.drawn {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// pause removes the status line and keeps it from being drawn while write prints to the terminal.
+func (p *progress) pause(write func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.erase()
+	write()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// BuildKit attaches its attestations to an image in a manifest of the index that points at the
+// image with these annotations
+const (
+	ANNOTATION_REFERENCE_TYPE   = "vnd.docker.reference.type"
+	ANNOTATION_REFERENCE_DIGEST = "vnd.docker.reference.digest"
+	ANNOTATION_PREDICATE_TYPE   = "in-toto.io/predicate-type"
+	ATTESTATION_MANIFEST        = "attestation-manifest"
+	SLSA_PROVENANCE             = "https://slsa.dev/provenance/"
+)
+
+// The BuildKit frontend a # syntax= line pulls is among the materials of a build without being
+// one of its base images
+const DOCKERFILE_FRONTEND = "docker/dockerfile"
+
+// declaredBase is a base image an image names itself, which is exact where layer matching guesses.
+type declaredBase struct {
+	name   string
+	digest string
+	// source is BASE_SOURCE_ANNOTATION or BASE_SOURCE_PROVENANCE
+	source string
+}
+
+// declaredBaseImage returns the base image named by the OCI base image annotations of the manifest,
+// the same keys set as labels, or the provenance BuildKit attached to the image, in that order.
+func declaredBaseImage(labels map[string]string, manifest manifestDetails) (base declaredBase, ok bool) {
+	for _, values := range []map[string]string{manifest.Annotations, labels} {
+		if name := values[ocispec.AnnotationBaseImageName]; name != "" {
+			return declaredBase{name: name, digest: values[ocispec.AnnotationBaseImageDigest], source: BASE_SOURCE_ANNOTATION}, true
+		}
+	}
+	if len(manifest.Provenance) > 0 {
+		if name, digest, ok := provenanceBase(manifest.Provenance); ok {
+			return declaredBase{name: name, digest: digest, source: BASE_SOURCE_PROVENANCE}, true
+		}
+	}
+	return base, false
+}
+
+// provenanceStatement is the part of an in-toto statement with a SLSA provenance predicate that
+// lists what a build used: materials in SLSA 0.2, resolved dependencies in SLSA 1.0.
+type provenanceStatement struct {
+	Predicate struct {
+		Materials       []provenanceMaterial `json:"materials"`
+		BuildDefinition struct {
+			ResolvedDependencies []provenanceMaterial `json:"resolvedDependencies"`
+		} `json:"buildDefinition"`
+	} `json:"predicate"`
+}
+
+type provenanceMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// provenanceBase finds the base image among the materials of a build, which BuildKit lists as
+// package URLs such as pkg:docker/alpine@3.19?platform=linux%2Famd64. A multi-stage build uses
+// several images and which one the last stage is built on is not recorded, so only a build that
+// used a single image gives its base.
+func provenanceBase(statement []byte) (name string, digest string, ok bool) {
+	var provenance provenanceStatement
+	if json.Unmarshal(statement, &provenance) != nil {
+		return "", "", false
+	}
+	materials := append(provenance.Predicate.Materials, provenance.Predicate.BuildDefinition.ResolvedDependencies...)
+	var found int
+	for _, material := range materials {
+		imageName, isImage := packageImageName(material.URI)
+		if !isImage || imageName == "" {
+			continue
+		}
+		if named, err := reference.ParseNormalizedNamed(imageName); err == nil && reference.FamiliarName(named) == DOCKERFILE_FRONTEND {
+			continue
+		}
+		found++
+		name = imageName
+		if sha, ok := material.Digest["sha256"]; ok {
+			digest = "sha256:" + sha
+		}
+	}
+	return name, digest, found == 1
+}
+
+// packageImageName turns the package URL of a docker image into an image name, e.g.
+// pkg:docker/alpine@3.19?platform=linux%2Famd64 into alpine:3.19.
+func packageImageName(uri string) (imageName string, ok bool) {
+	rest, ok := strings.CutPrefix(uri, "pkg:docker/")
+	if !ok {
+		return "", false
+	}
+	rest, _, _ = strings.Cut(rest, "?")
+	path, version, _ := strings.Cut(rest, "@")
+	path, err := url.PathUnescape(path)
+	if err != nil {
+		return "", true
+	}
+	version, err = url.PathUnescape(version)
+	if err != nil || version == "" {
+		return path, true
+	}
+	if strings.HasPrefix(version, "sha256:") {
+		return path + "@" + version, true
+	}
+	return path + ":" + version, true
+}
+
+// indexProvenance returns the SLSA provenance BuildKit attached to an image of an index, if any.
+func indexProvenance(index v1.ImageIndex, imageDigest v1.Hash) (statement []byte, err error) {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, descriptor := range manifest.Manifests {
+		if descriptor.Annotations[ANNOTATION_REFERENCE_TYPE] != ATTESTATION_MANIFEST || descriptor.Annotations[ANNOTATION_REFERENCE_DIGEST] != imageDigest.String() {
+			continue
+		}
+		attestation, err := index.Image(descriptor.Digest)
+		if err != nil {
+			return nil, err
+		}
+		attestationManifest, err := attestation.Manifest()
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range attestationManifest.Layers {
+			if !strings.HasPrefix(layer.Annotations[ANNOTATION_PREDICATE_TYPE], SLSA_PROVENANCE) {
+				continue
+			}
+			blob, err := attestation.LayerByDigest(layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+			// The statement is stored uncompressed
+			reader, err := blob.Compressed()
+			if err != nil {
+				return nil, err
+			}
+			defer reader.Close()
+			return io.ReadAll(reader)
+		}
+	}
+	return nil, nil
+}
+
+// findDeclaredBase looks the base image an image names up in the image list, by its digest or its
+// name, so the steps of the base image can be left out of the history.
+func findDeclaredBase(imageList []image.Summary, declared declaredBase) (imageId string) {
+	normalized := normalizeImageName(declared.name)
+	for _, img := range imageList {
+		if declared.digest != "" && slices.ContainsFunc(img.RepoDigests, func(repoDigest string) bool {
+			return strings.HasSuffix(repoDigest, "@"+declared.digest)
+		}) {
+			return img.ID
+		}
+		if hasImageName(img, normalized) {
+			return img.ID
+		}
+	}
+	return ""
+}
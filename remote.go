@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/authn/github"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// newRemoteBackend reads the configs of the requested images straight from their registries, so a
+// Dockerfile can be reconstructed without pulling the image. No other images are known, so the FROM
+// image can only be found when the build recorded it. Registries with a mirror in the config file are
+// only reached through the mirror.
+func newRemoteBackend(opts *Options, config *Config) (backend *indexBackend, err error) {
+	keychain := remoteKeychain(opts.Keychain)
+	return &indexBackend{
+		source: fmt.Sprintf("remote mode, which only fetches %s", strings.Join(opts.ImageNames, ", ")),
+		load: func(ctx context.Context) (*ociImageIndex, error) {
+			index := newOciImageIndex()
+			defer PROGRESS.clear()
+			for i, imageName := range opts.ImageNames {
+				PROGRESS.update("fetching image %d/%d from the registry", i+1, len(opts.ImageNames))
+				err := loadRemoteImage(ctx, index, imageName, requestedPlatform(opts), keychain, config.mirrors)
+				if err != nil {
+					return nil, err
+				}
+			}
+			index.sortNames()
+			return index, nil
+		},
+	}, nil
+}
+
+// KEYCHAINS are the go-containerregistry keychains --keychain chooses from. The anonymous one
+// resolves nothing, so no credentials are sent.
+var KEYCHAINS = map[string]authn.Keychain{
+	"docker":    authn.DefaultKeychain,
+	"cloud":     cloudKeychain{},
+	"github":    github.Keychain,
+	"anonymous": authn.NewMultiKeychain(),
+}
+
+// remoteKeychain combines the keychains chosen with --keychain, tried in order. By default
+// credentials come from the docker config, its credsStore and credHelpers, or Podman's auth.json,
+// and for cloud registries without any from the provider's helper or CLI.
+func remoteKeychain(names []string) (keychain authn.Keychain) {
+	if len(names) == 0 {
+		names = []string{"docker", "cloud"}
+	}
+	var keychains []authn.Keychain
+	for _, keychainName := range names {
+		keychains = append(keychains, KEYCHAINS[keychainName])
+	}
+	return authn.NewMultiKeychain(keychains...)
+}
+
+// loadRemoteImage fetches an image by any reference crane accepts, such as a tag, a digest or the
+// index.docker.io form crane prints.
+func loadRemoteImage(ctx context.Context, index *ociImageIndex, imageName string, platform v1.Platform, keychain authn.Keychain, mirrors map[string]string) (err error) {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return fmt.Errorf("the image name %s is not a valid reference: %w", imageName, err)
+	}
+	ref, err = mirrorReference(ref, mirrors)
+	if err != nil {
+		return fmt.Errorf("unable to map %s to its mirror: %w", imageName, err)
+	}
+	descriptor, err := remote.Get(ref,
+		remote.WithContext(ctx),
+		remote.WithPlatform(platform),
+		remote.WithAuthFromKeychain(keychain),
+	)
+	if err != nil {
+		return remoteError(ref, err)
+	}
+	// Legacy registries may only have a schema 1 manifest, which has no config to fetch
+	if descriptor.MediaType == types.DockerManifestSchema1 || descriptor.MediaType == types.DockerManifestSchema1Signed {
+		id, config, err := schema1Config(descriptor.Manifest)
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", ref, err)
+		}
+		entry := index.add(id, config)
+		entry.manifest.Schema1 = true
+		index.addName(id, imageName, descriptor.Digest.String())
+		return nil
+	}
+	img, err := descriptor.Image()
+	if err != nil {
+		return fmt.Errorf("unable to fetch %s from the registry: %w", ref, err)
+	}
+	configName, err := img.ConfigName()
+	if err != nil {
+		return fmt.Errorf("unable to read the config of %s: %w", ref, err)
+	}
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		return fmt.Errorf("unable to fetch the config of %s: %w", ref, err)
+	}
+	var config dockerImage
+	err = json.Unmarshal(rawConfig, &config)
+	if err != nil {
+		return fmt.Errorf("unable to parse the config of %s: %w", ref, err)
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return fmt.Errorf("unable to read the manifest of %s: %w", ref, err)
+	}
+
+	entry := index.add(configName.String(), config)
+	for _, layer := range manifest.Layers {
+		if isForeignLayer(string(layer.MediaType)) {
+			entry.manifest.ForeignLayers++
+		}
+	}
+	entry.manifest.Annotations = manifest.Annotations
+	// BuildKit attaches its provenance to the index of the image. One that cannot be fetched only
+	// leaves the base image to be found by its layers.
+	if descriptor.MediaType.IsIndex() {
+		if imageIndex, err := descriptor.ImageIndex(); err == nil {
+			if imageDigest, err := img.Digest(); err == nil {
+				entry.manifest.Provenance, _ = indexProvenance(imageIndex, imageDigest)
+			}
+		}
+	}
+	index.addName(configName.String(), imageName, descriptor.Digest.String())
+	return nil
+}
+
+// remoteError explains registry errors, pointing at docker login when the registry refused access.
+func remoteError(ref name.Reference, err error) error {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) && (transportErr.StatusCode == http.StatusUnauthorized || transportErr.StatusCode == http.StatusForbidden) {
+		return fmt.Errorf("access to %s was denied - log in with \"docker login %s\" or configure a credential helper: %w", ref, ref.Context().RegistryStr(), err)
+	}
+	return fmt.Errorf("unable to fetch %s from the registry: %w", ref, err)
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/distribution/reference"
+)
+
+// jsonDocument is the JSON form of an extraction, used by --format json and a bundle's metadata.json.
+type jsonDocument struct {
+	DfimageVersion string     `json:"dfimage_version"`
+	GeneratedAt    *time.Time `json:"generated_at,omitempty"`
+	extraction
+	Warnings []string  `json:"warnings,omitempty"`
+	Findings []finding `json:"findings,omitempty"`
+
+	// annotateBuilders adds a comment naming the builder to the Dockerfile wherever it changes
+	annotateBuilders bool
+}
+
+type renderCommand struct {
+	Args struct {
+		Bundle string `positional-arg-name:"bundle.tar.gz" description:"A bundle written with --bundle."`
+	} `positional-args:"yes" required:"yes"`
+	opts *Options
+}
+
+// newJsonDocument wraps a result for JSON output. In deterministic mode the generation time is left out.
+func newJsonDocument(result extraction, deterministic bool) (document jsonDocument) {
+	document = jsonDocument{
+		DfimageVersion: VERSION,
+		extraction:     result,
+	}
+	if !deterministic {
+		now := time.Now().UTC()
+		document.GeneratedAt = &now
+	}
+	return document
+}
+
+// renderWarnings writes the warnings as comments, which Dockerfiles and YAML both understand.
+func renderWarnings(warnings []string) (comments string) {
+	for _, warning := range warnings {
+		comments += fmt.Sprintf("# Warning: %s\n", warning)
+	}
+	return comments
+}
+
+// renderDockerfile renders the instructions wrapped in the configured header and footer, after any
+// warnings about the image.
+func renderDockerfile(document jsonDocument, config *Config) (dockerfile string, err error) {
+	header, err := executeConfigTemplate(config.header, document)
+	if err != nil {
+		return "", err
+	}
+	footer, err := executeConfigTemplate(config.footer, document)
+	if err != nil {
+		return "", err
+	}
+	var instructions []string
+	for i, comments := range instructionComments(document) {
+		instructions = append(instructions, comments...)
+		instructions = append(instructions, document.Instructions[i])
+	}
+	return renderWarnings(document.Warnings) + header + strings.Join(instructions, "\n") + "\n" + footer, nil
+}
+
+// instructionComments returns the comment lines to write above every instruction: the confidence
+// in the base image above FROM, the builders added by --annotate-builders and the content hashes
+// of the COPY and ADD instructions written with a placeholder source.
+func instructionComments(document jsonDocument) (comments [][]string) {
+	comments = make([][]string, len(document.Instructions))
+	if len(comments) > 0 {
+		if comment := confidenceComment(document.BaseConfidence); comment != "" {
+			comments[0] = append(comments[0], comment)
+		}
+	}
+	for i, comment := range builderComments(document) {
+		if comment != "" {
+			comments[i] = append(comments[i], comment)
+		}
+	}
+	if len(document.Contents) == len(comments) {
+		for i, content := range document.Contents {
+			if content != "" {
+				comments[i] = append(comments[i], fmt.Sprintf("# Content: %s", content))
+			}
+		}
+	}
+	return comments
+}
+
+func renderJson(document jsonDocument) (output string, err error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(document)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode the result as JSON: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// jsonLine is the summary of one image written by --format jsonl, small enough for log pipelines.
+type jsonLine struct {
+	Image        string    `json:"image"`
+	ImageID      string    `json:"image_id,omitempty"`
+	Status       string    `json:"status"`
+	BaseImage    string    `json:"base_image,omitempty"`
+	Instructions []string  `json:"instructions,omitempty"`
+	Warnings     []string  `json:"warnings,omitempty"`
+	Findings     []finding `json:"findings,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// renderJsonLine writes the image as a single line of JSON, so a batch run prints one line per
+// image. The status is policy-failed when a finding is an error, and ok otherwise.
+func renderJsonLine(document jsonDocument) (output string, err error) {
+	line := jsonLine{
+		Image:        document.Image,
+		ImageID:      document.ImageID,
+		Status:       "ok",
+		BaseImage:    document.BaseImage,
+		Instructions: document.Instructions,
+		Warnings:     document.Warnings,
+		Findings:     document.Findings,
+	}
+	if slices.ContainsFunc(document.Findings, func(f finding) bool { return f.Severity == "error" }) {
+		line.Status = "policy-failed"
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(line)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode the result as JSON: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// renderJsonErrorLine writes the line of an image of a batch that could not be inspected.
+func renderJsonErrorLine(imageName string, inspectErr error) (output string, err error) {
+	line, err := json.Marshal(jsonLine{Image: imageName, Status: "error", Error: inspectErr.Error()})
+	if err != nil {
+		return "", fmt.Errorf("unable to encode the result as JSON: %w", err)
+	}
+	return string(line) + "\n", nil
+}
+
+// renderJsonUnchangedLine writes the line of an image of a batch that has not changed since the
+// last run, which only recorded its base image.
+func renderJsonUnchangedLine(summary imageSummary) (output string, err error) {
+	line, err := json.Marshal(jsonLine{Image: summary.Image, Status: "unchanged", BaseImage: summary.BaseImage})
+	if err != nil {
+		return "", fmt.Errorf("unable to encode the result as JSON: %w", err)
+	}
+	return string(line) + "\n", nil
+}
+
+func renderMarkdown(document jsonDocument, config *Config) (output string, err error) {
+	var b strings.Builder
+	cell := func(value string) string {
+		return strings.ReplaceAll(value, "|", "\\|")
+	}
+	row := func(name string, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "| %s | %s |\n", name, cell(value))
+		}
+	}
+
+	fmt.Fprintf(&b, "# %s\n\n", document.Image)
+	b.WriteString("| | |\n|---|---|\n")
+	row("Image ID", "`"+document.ImageID+"`")
+	row("Tags", strings.Join(document.RepoTags, ", "))
+	row("Digests", strings.Join(document.RepoDigests, ", "))
+	if document.OS != "" {
+		row("Platform", strings.TrimSuffix(document.OS+"/"+document.Architecture+"/"+document.Variant, "/"))
+	}
+	row("Created", document.Created)
+	row("Built by", builderSummary(document.Builders))
+	if document.BaseImage != "" {
+		row("Base image", document.BaseImage)
+	} else {
+		row("Base image", "not found locally")
+	}
+	if document.BaseConfidence != nil {
+		row("Base image confidence", fmt.Sprintf("%d%%", document.BaseConfidence.Score))
+	}
+	if document.GeneratedAt != nil {
+		row("Generated", fmt.Sprintf("dfimage %s at %s", document.DfimageVersion, document.GeneratedAt.Format(time.RFC3339)))
+	} else {
+		row("Generated", "dfimage "+document.DfimageVersion)
+	}
+	for _, warning := range document.Warnings {
+		fmt.Fprintf(&b, "\n> **Warning:** %s\n", warning)
+	}
+	// The warnings are already shown above the Dockerfile
+	withoutWarnings := document
+	withoutWarnings.Warnings = nil
+	dockerfile, err := renderDockerfile(withoutWarnings, config)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&b, "\n## Dockerfile\n\n```dockerfile\n%s```\n", dockerfile)
+
+	if len(document.Findings) > 0 {
+		b.WriteString("\n## Findings\n\n| Rule | Severity | Message |\n|---|---|---|\n")
+		for _, f := range document.Findings {
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", cell(f.RuleID), f.Severity, cell(f.Message))
+		}
+	}
+	return b.String(), nil
+}
+
+func renderOutput(format string, document jsonDocument, config *Config) (output string, err error) {
+	switch format {
+	case "", "dockerfile":
+		return renderDockerfile(document, config)
+	case "json":
+		return renderJson(document)
+	case "markdown":
+		return renderMarkdown(document, config)
+	case "sarif":
+		return renderSarif(document, config)
+	case "k8s":
+		return renderKubernetes(document)
+	case "systemd":
+		return renderSystemd(document)
+	case "jsonl":
+		return renderJsonLine(document)
+	}
+	return "", fmt.Errorf("unknown output format \"%s\"", format)
+}
+
+// writeOutput prints output to stdout or, when outputFile is set, writes it to that file.
+// With update, an existing file is only rewritten when its normalized content changed, and the
+// lines that changed are summarized.
+func writeOutput(stdout io.Writer, outputFile string, output string, update bool) (err error) {
+	if outputFile == "" {
+		_, err = fmt.Fprint(stdout, output)
+		return err
+	}
+	if update {
+		existing, err := os.ReadFile(outputFile)
+		if err == nil {
+			return updateOutput(stdout, outputFile, string(existing), output)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	err = os.WriteFile(outputFile, []byte(output), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "File successfully written to %s.\n", outputFile)
+	return nil
+}
+
+// GENERATED_LINE matches the lines that only record when an output was generated.
+var GENERATED_LINE = regexp.MustCompile(`(?m)^\s*"generated_at": ".*",?\n|^\| Generated \| .* \|\n`)
+
+// UPDATE_SUMMARY_LINES bounds the changed lines printed when a file is updated.
+const UPDATE_SUMMARY_LINES = 20
+
+// normalizeOutput leaves out what changes on every run, the generation time, and the line endings
+// and trailing whitespace editors may change.
+func normalizeOutput(output string) (lines []string) {
+	output = GENERATED_LINE.ReplaceAllString(strings.ReplaceAll(output, "\r\n", "\n"), "")
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		lines = append(lines, strings.TrimRight(line, " \t"))
+	}
+	return lines
+}
+
+// updateOutput rewrites a file only when the normalized output differs from its content, so an
+// unchanged file keeps its modification time.
+func updateOutput(stdout io.Writer, outputFile string, existing string, output string) (err error) {
+	changes := diffLines(normalizeOutput(existing), normalizeOutput(output))
+	if len(changes) == 0 {
+		fmt.Fprintf(stdout, "%s is up to date.\n", outputFile)
+		return nil
+	}
+	err = os.WriteFile(outputFile, []byte(output), 0644)
+	if err != nil {
+		return err
+	}
+	var added, removed int
+	for _, change := range changes {
+		if strings.HasPrefix(change, "+") {
+			added++
+		} else {
+			removed++
+		}
+	}
+	fmt.Fprintf(stdout, "File successfully updated at %s (+%d -%d lines).\n", outputFile, added, removed)
+	for i, change := range changes {
+		if i == UPDATE_SUMMARY_LINES {
+			fmt.Fprintf(stdout, "  ... and %d more\n", len(changes)-i)
+			break
+		}
+		fmt.Fprintf(stdout, "  %s\n", change)
+	}
+	return nil
+}
+
+// FILENAME_TEMPLATES name the files written to --output-dir when no --filename-template is given.
+var FILENAME_TEMPLATES = map[string]string{
+	"dockerfile": "{{.Repo}}_{{.Tag}}.Dockerfile",
+	"json":       "{{.Repo}}_{{.Tag}}.json",
+	"markdown":   "{{.Repo}}_{{.Tag}}.md",
+	"sarif":      "{{.Repo}}_{{.Tag}}.sarif",
+	"k8s":        "{{.Repo}}_{{.Tag}}.yaml",
+	"systemd":    "{{.Repo}}_{{.Tag}}.container",
+	"jsonl":      "{{.Repo}}_{{.Tag}}.jsonl",
+}
+
+// outputFileName holds the fields a --filename-template is executed against.
+type outputFileName struct {
+	// Registry is the registry of the image, e.g. docker.io
+	Registry string
+	// Repo is the repository without the registry, with / replaced by _, e.g. myorg_app
+	Repo string
+	// Tag is the tag of the image, or none for an image known only by its ID
+	Tag string
+}
+
+// parseFilenameTemplate parses --filename-template, or the default one for the output format.
+func parseFilenameTemplate(text string, format string) (tmpl *template.Template, err error) {
+	if text == "" {
+		text = FILENAME_TEMPLATES[format]
+	}
+	tmpl, err = template.New("filename").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --filename-template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// outputPath returns the path in outputDir the output for an image is written to. Names that would
+// leave outputDir are refused, but a template may place files in subdirectories.
+func outputPath(outputDir string, tmpl *template.Template, repoTag string) (path string, err error) {
+	name := outputFileName{Repo: repoTag, Tag: "none"}
+	if named, err := reference.ParseNormalizedNamed(repoTag); err == nil {
+		name.Registry = reference.Domain(named)
+		repo := reference.Path(named)
+		if name.Registry == "docker.io" {
+			repo = strings.TrimPrefix(repo, "library/")
+		}
+		name.Repo = strings.ReplaceAll(repo, "/", "_")
+		if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok {
+			name.Tag = tagged.Tag()
+		}
+	} else if len(name.Repo) > 12 {
+		// An image known only by its ID is named after its short ID
+		name.Repo = name.Repo[:12]
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, name)
+	if err != nil {
+		return "", fmt.Errorf("unable to name the output file of %s: %w", repoTag, err)
+	}
+	relative := filepath.Clean(buf.String())
+	if !filepath.IsLocal(relative) {
+		return "", fmt.Errorf("the --filename-template gives %s the name %s, which is outside --output-dir", repoTag, buf.String())
+	}
+	path = filepath.Join(outputDir, relative)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", fmt.Errorf("unable to create the output directory: %w", err)
+	}
+	return path, nil
+}
+
+func readBundle(path string) (document jsonDocument, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return document, fmt.Errorf("unable to open the bundle: %w", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return document, fmt.Errorf("%s is not a dfimage bundle: %w", path, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return document, fmt.Errorf("%s is not a dfimage bundle - metadata.json is missing", path)
+		} else if err != nil {
+			return document, fmt.Errorf("unable to read the bundle: %w", err)
+		}
+		if header.Name != "metadata.json" {
+			continue
+		}
+		err = json.NewDecoder(tr).Decode(&document)
+		if err != nil {
+			return document, fmt.Errorf("unable to parse the bundle metadata: %w", err)
+		}
+		return document, nil
+	}
+}
+
+func (c *renderCommand) Execute(args []string) (err error) {
+	config, err := loadConfig(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+	document, err := readBundle(c.Args.Bundle)
+	if err != nil {
+		return err
+	}
+	document.annotateBuilders = c.opts.AnnotateBuilders
+	output, err := renderOutput(c.opts.Format, document, config)
+	if err != nil {
+		return err
+	}
+	return writeOutput(os.Stdout, c.opts.OutputFile, output, c.opts.Update)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+type retryPolicy struct {
+	Attempts int
+	Backoff  time.Duration
+	Jitter   float64
+}
+
+// dockerClient wraps the Docker API client so that image calls are retried on transient errors.
+type dockerClient struct {
+	*client.Client
+	retry retryPolicy
+	// host is the daemon the client talks to, as resolved from the options, the context or DOCKER_HOST
+	host string
+}
+
+// isTransientError reports whether err looks like a daemon hiccup (a dropped connection, a restart
+// or a 5xx response) rather than a permanent failure such as a missing image.
+func isTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		client.IsErrConnectionFailed(err) ||
+		errdefs.IsUnavailable(err) ||
+		errdefs.IsSystem(err)
+}
+
+func (p retryPolicy) do(ctx context.Context, call func() error) (err error) {
+	delay := p.Backoff
+	for attempt := 0; ; attempt++ {
+		err = call()
+		if err == nil || attempt >= p.Attempts || !isTransientError(err) {
+			return err
+		}
+		wait := delay + time.Duration(rand.Float64()*p.Jitter*float64(delay))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+func (c *dockerClient) ImageList(ctx context.Context, options image.ListOptions) (imageList []image.Summary, err error) {
+	err = c.retry.do(ctx, func() (err error) {
+		STATS.call(CALL_LIST)
+		imageList, err = c.Client.ImageList(ctx, options)
+		return err
+	})
+	return imageList, err
+}
+
+func (c *dockerClient) ImageInspectWithRaw(ctx context.Context, imageId string) (inspect types.ImageInspect, raw []byte, err error) {
+	err = c.retry.do(ctx, func() (err error) {
+		STATS.call(CALL_INSPECT)
+		inspect, raw, err = c.Client.ImageInspectWithRaw(ctx, imageId)
+		return err
+	})
+	return inspect, raw, err
+}
+
+func (c *dockerClient) ImageHistory(ctx context.Context, imageId string) (imageHistory []image.HistoryResponseItem, err error) {
+	err = c.retry.do(ctx, func() (err error) {
+		STATS.call(CALL_HISTORY)
+		imageHistory, err = c.Client.ImageHistory(ctx, imageId)
+		return err
+	})
+	return imageHistory, err
+}
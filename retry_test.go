@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{
+			name:      "a dropped connection",
+			err:       fmt.Errorf("unable to inspect the image: %w", io.ErrUnexpectedEOF),
+			transient: true,
+		},
+		{
+			name:      "a reset connection",
+			err:       &net.OpError{Op: "read", Net: "unix", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+			transient: true,
+		},
+		{
+			name:      "a daemon that is restarting",
+			err:       errdefs.Unavailable(errors.New("the daemon is shutting down")),
+			transient: true,
+		},
+		{
+			name:      "a server error",
+			err:       errdefs.System(errors.New("internal server error")),
+			transient: true,
+		},
+		{
+			name: "a missing image",
+			err:  errdefs.NotFound(errors.New("No such image: app:1.0")),
+		},
+		{
+			name: "a denied request",
+			err:  errdefs.Forbidden(errors.New("access denied")),
+		},
+		{
+			name: "a canceled run",
+			err:  context.Canceled,
+		},
+		{
+			name: "a canceled run that dropped the connection",
+			err:  errors.Join(context.Canceled, io.EOF),
+		},
+		{
+			name: "a timeout",
+			err:  fmt.Errorf("unable to list the images: %w", context.DeadlineExceeded),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isTransientError(test.err); got != test.transient {
+				t.Errorf("isTransientError() = %t, want %t", got, test.transient)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	transient := errdefs.Unavailable(errors.New("the daemon is restarting"))
+	tests := []struct {
+		name     string
+		attempts int
+		// errs are what the calls return in turn, the last one from then on
+		errs  []error
+		calls int
+		err   error
+	}{
+		{
+			name:     "a call that succeeds",
+			attempts: 3,
+			errs:     []error{nil},
+			calls:    1,
+		},
+		{
+			name:     "a call that succeeds after a transient error",
+			attempts: 3,
+			errs:     []error{transient, nil},
+			calls:    2,
+		},
+		{
+			name:     "a transient error every time",
+			attempts: 3,
+			errs:     []error{transient},
+			calls:    4,
+			err:      transient,
+		},
+		{
+			name:  "no retries",
+			errs:  []error{transient},
+			calls: 1,
+			err:   transient,
+		},
+		{
+			name:     "a missing image",
+			attempts: 3,
+			errs:     []error{errdefs.NotFound(errors.New("No such image: app:1.0"))},
+			calls:    1,
+		},
+		{
+			name:     "a canceled run",
+			attempts: 3,
+			errs:     []error{context.Canceled},
+			calls:    1,
+			err:      context.Canceled,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := retryPolicy{Attempts: test.attempts, Backoff: time.Millisecond, Jitter: 0.5}
+			var calls int
+			err := policy.do(context.Background(), func() error {
+				err := test.errs[min(calls, len(test.errs)-1)]
+				calls++
+				return err
+			})
+			if calls != test.calls {
+				t.Errorf("do() made %d calls, want %d", calls, test.calls)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Errorf("do() error = %v, want %v", err, test.err)
+			}
+			if test.calls == 1 && test.errs[0] != nil && err != test.errs[0] {
+				t.Errorf("do() error = %v, want the error of the call", err)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoCanceled(t *testing.T) {
+	// A run canceled while waiting for the next attempt stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := retryPolicy{Attempts: 5, Backoff: time.Hour}
+	var calls int
+	done := make(chan error)
+	go func() {
+		done <- policy.do(ctx, func() error {
+			calls++
+			return io.EOF
+		})
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, io.EOF) || calls != 1 {
+			t.Errorf("do() = %v after %d calls, want io.EOF after 1", err, calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("do() kept waiting after the run was canceled")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// runRecord is what the last batch run reported about an image, so an image that has not changed
+// since can be skipped.
+type runRecord struct {
+	Created      time.Time `json:"created"`
+	Image        string    `json:"image"`
+	ImageID      string    `json:"image_id"`
+	Source       string    `json:"source,omitempty"`
+	ConfigDigest string    `json:"config_digest"`
+	BaseImage    string    `json:"base_image,omitempty"`
+	Instructions int       `json:"instructions"`
+	Warnings     int       `json:"warnings"`
+	PolicyFailed bool      `json:"policy_failed,omitempty"`
+}
+
+func runRecordDir() (dir string, err error) {
+	return cacheSubdir("runs")
+}
+
+// runRecordKey identifies the runs of an image by its name, the file its output goes to, empty for
+// STDOUT, and the options that change what is reported about it.
+func runRecordKey(imageName string, outputFile string, opts *Options) (key string) {
+	h := sha256.New()
+	fmt.Fprintf(h, "image=%s\n", imageName)
+	fmt.Fprintf(h, "format=%s\n", opts.Format)
+	fmt.Fprintf(h, "group-labels=%t\n", opts.GroupLabels)
+	fmt.Fprintf(h, "collapse-workdirs=%t\n", opts.CollapseWorkdirs)
+	fmt.Fprintf(h, "pin-digests=%t\n", opts.PinDigests)
+	fmt.Fprintf(h, "lookup-bases=%t\n", opts.LookupBases)
+	fmt.Fprintf(h, "policy=%s\n", opts.PolicyFile)
+	fmt.Fprintf(h, "baseline=%s\n", opts.Baseline)
+	fmt.Fprintf(h, "output=%s\n", outputFile)
+	fmt.Fprintf(h, "update=%t\n", opts.Update)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// imageConfigDigest hashes the configuration and layers of an image as read, which is recorded
+// along with the image ID.
+func imageConfigDigest(inspect types.ImageInspect) (digest string, err error) {
+	contents, err := json.Marshal(struct {
+		Config       any      `json:"config"`
+		Os           string   `json:"os"`
+		Architecture string   `json:"architecture"`
+		Variant      string   `json:"variant"`
+		Layers       []string `json:"layers"`
+	}{inspect.Config, inspect.Os, inspect.Architecture, inspect.Variant, inspect.RootFS.Layers})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(contents)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+func readRunRecord(key string) (record runRecord, ok bool) {
+	dir, err := runRecordDir()
+	if err != nil {
+		return record, false
+	}
+	contents, err := os.ReadFile(filepath.Join(dir, key+".json"))
+	if err != nil {
+		return record, false
+	}
+	if json.Unmarshal(contents, &record) != nil || record.ImageID == "" {
+		return record, false
+	}
+	return record, true
+}
+
+func writeRunRecord(key string, record runRecord) (err error) {
+	dir, err := runRecordDir()
+	if err != nil {
+		return err
+	}
+	contents, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(filepath.Join(dir, key+".json"), contents)
+}
+
+func readRunRecordEntries() (entries []cacheEntry, err error) {
+	dir, err := runRecordDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		entry := cacheEntry{Path: path, Size: info.Size(), Kind: CACHE_RUN}
+		var record runRecord
+		contents, err := os.ReadFile(path)
+		if err == nil && json.Unmarshal(contents, &record) == nil && !record.Created.IsZero() {
+			entry.Result.Created = record.Created
+		} else {
+			entry.Result.Created = info.ModTime()
+		}
+		entry.Result.Extraction.Image = record.Image
+		entry.Result.Extraction.ImageID = record.ImageID
+		entry.Result.Source = record.Source
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
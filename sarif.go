@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const SARIF_SCHEMA = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// The subset of SARIF 2.1.0 needed to report findings against the generated Dockerfile.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool         `json:"tool"`
+	Artifacts  []sarifArtifact   `json:"artifacts"`
+	Results    []sarifResult     `json:"results"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifArtifact struct {
+	Location sarifArtifactLocation `json:"location"`
+	Contents struct {
+		Text string `json:"text"`
+	} `json:"contents"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           struct {
+			StartLine int `json:"startLine"`
+			EndLine   int `json:"endLine"`
+		} `json:"region"`
+	} `json:"physicalLocation"`
+}
+
+// instructionLines returns the first and last line of every instruction in the rendered Dockerfile,
+// taking the warnings, the configured header and instructions spanning several lines into account.
+func instructionLines(document jsonDocument, config *Config) (first []int, last []int, err error) {
+	header, err := executeConfigTemplate(config.header, document)
+	if err != nil {
+		return nil, nil, err
+	}
+	line := len(document.Warnings) + strings.Count(header, "\n") + 1
+	comments := instructionComments(document)
+	for i, instruction := range document.Instructions {
+		line += len(comments[i])
+		first = append(first, line)
+		line += strings.Count(instruction, "\n")
+		last = append(last, line)
+		line++
+	}
+	return first, last, nil
+}
+
+// renderSarif reports the findings as a SARIF log against a file named Dockerfile holding the
+// generated Dockerfile. Findings about no instruction in particular point at the FROM line.
+func renderSarif(document jsonDocument, config *Config) (output string, err error) {
+	dockerfile, err := renderDockerfile(document, config)
+	if err != nil {
+		return "", err
+	}
+	first, last, err := instructionLines(document, config)
+	if err != nil {
+		return "", err
+	}
+
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "dfimage",
+			Version:        document.DfimageVersion,
+			InformationURI: "https://github.com/gdanko/dfimage",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+		Properties: map[string]string{
+			"image":    document.Image,
+			"image_id": document.ImageID,
+		},
+	}
+	artifact := sarifArtifact{Location: sarifArtifactLocation{URI: "Dockerfile"}}
+	artifact.Contents.Text = dockerfile
+	run.Artifacts = []sarifArtifact{artifact}
+
+	seen := map[string]bool{}
+	for _, f := range document.Findings {
+		if !seen[f.RuleID] {
+			seen[f.RuleID] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: f.RuleID})
+		}
+		level := "error"
+		if f.Severity == "warning" {
+			level = "warning"
+		}
+		var location sarifLocation
+		location.PhysicalLocation.ArtifactLocation.URI = "Dockerfile"
+		location.PhysicalLocation.Region.StartLine = 1
+		location.PhysicalLocation.Region.EndLine = 1
+		if len(first) > 0 {
+			location.PhysicalLocation.Region.StartLine = first[0]
+			location.PhysicalLocation.Region.EndLine = last[0]
+		}
+		if f.Instruction > 0 && f.Instruction <= len(first) {
+			location.PhysicalLocation.Region.StartLine = first[f.Instruction-1]
+			location.PhysicalLocation.Region.EndLine = last[f.Instruction-1]
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    f.RuleID,
+			Level:     level,
+			Message:   sarifMessage{Text: f.Message},
+			Locations: []sarifLocation{location},
+		})
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(sarifLog{Schema: SARIF_SCHEMA, Version: "2.1.0", Runs: []sarifRun{run}})
+	if err != nil {
+		return "", fmt.Errorf("unable to encode the findings as SARIF: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestRenderSarifLines(t *testing.T) {
+	tests := []struct {
+		name         string
+		instructions []string
+		user         string
+		labels       map[string]string
+		// lines are the start lines of the root user and the missing label findings
+		lines []int
+	}{
+		{
+			name: "the last USER and LABEL instructions",
+			instructions: []string{
+				"FROM alpine:3.20",
+				"LABEL org.opencontainers.image.title=app",
+				"USER app",
+				"RUN make",
+				"USER root",
+				"LABEL org.opencontainers.image.version=1.0",
+				`CMD ["app"]`,
+			},
+			user:   "root",
+			labels: map[string]string{"org.opencontainers.image.title": "app", "org.opencontainers.image.version": "1.0"},
+			lines:  []int{5, 6},
+		},
+		{
+			name:         "the final line without USER and LABEL instructions",
+			instructions: []string{"FROM alpine:3.20", "RUN make", `CMD ["app"]`},
+			lines:        []int{3, 3},
+		},
+		{
+			name: "a LABEL instruction spanning several lines",
+			instructions: []string{
+				"FROM alpine:3.20",
+				"LABEL org.opencontainers.image.title=app \\\n      org.opencontainers.image.version=1.0",
+				`CMD ["app"]`,
+			},
+			labels: map[string]string{"org.opencontainers.image.title": "app", "org.opencontainers.image.version": "1.0"},
+			lines:  []int{4, 2},
+		},
+	}
+	rule := policyRule{
+		ID:             "non-root",
+		Severity:       "error",
+		ForbidRootUser: true,
+		RequireLabels:  []string{"org.opencontainers.image.source"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := extraction{Image: "app:1.0", Instructions: test.instructions}
+			result.Config.User = test.user
+			result.Config.Labels = test.labels
+			document := newJsonDocument(result, true)
+			document.Findings = rule.evaluate(result)
+			output, err := renderSarif(document, &Config{})
+			if err != nil {
+				t.Fatalf("renderSarif() error = %v", err)
+			}
+			var log sarifLog
+			err = json.Unmarshal([]byte(output), &log)
+			if err != nil {
+				t.Fatalf("renderSarif() wrote invalid JSON: %v", err)
+			}
+			var lines []int
+			for _, result := range log.Runs[0].Results {
+				lines = append(lines, result.Locations[0].PhysicalLocation.Region.StartLine)
+			}
+			if !slices.Equal(lines, test.lines) {
+				t.Errorf("renderSarif() reported the findings at lines %v, want %v", lines, test.lines)
+			}
+		})
+	}
+}
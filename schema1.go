@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// schema1Manifest is a Docker image manifest of schema version 1, which legacy registries still
+// serve for images pushed by Docker before 1.10. It has no image config; every history entry
+// instead carries the v1 JSON of one layer, newest first.
+type schema1Manifest struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Architecture  string `json:"architecture"`
+	History       []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history"`
+}
+
+// schema1Layer is the v1 JSON of a layer. The one of the newest layer holds the image config.
+type schema1Layer struct {
+	Created         *time.Time        `json:"created"`
+	Author          string            `json:"author"`
+	Comment         string            `json:"comment"`
+	OS              string            `json:"os"`
+	Architecture    string            `json:"architecture"`
+	Config          dockerImageConfig `json:"config"`
+	ContainerConfig struct {
+		Cmd []string `json:"Cmd"`
+	} `json:"container_config"`
+	ThrowAway bool `json:"throwaway"`
+}
+
+// schema1Config builds the image config a schema 1 manifest implies, with the history the layers
+// record. The ID is derived from the manifest, since the image has no config of its own.
+func schema1Config(rawManifest []byte) (id string, config dockerImage, err error) {
+	var manifest schema1Manifest
+	err = json.Unmarshal(rawManifest, &manifest)
+	if err != nil {
+		return "", config, fmt.Errorf("unable to parse the schema 1 manifest: %w", err)
+	}
+	if manifest.SchemaVersion != 1 || len(manifest.History) == 0 {
+		return "", config, fmt.Errorf("the schema 1 manifest has no history")
+	}
+
+	for i := len(manifest.History) - 1; i >= 0; i-- {
+		var layer schema1Layer
+		err = json.Unmarshal([]byte(manifest.History[i].V1Compatibility), &layer)
+		if err != nil {
+			return "", config, fmt.Errorf("unable to parse the history of the schema 1 manifest: %w", err)
+		}
+		config.History = append(config.History, ocispec.History{
+			Created:    layer.Created,
+			CreatedBy:  strings.Join(layer.ContainerConfig.Cmd, " "),
+			Author:     layer.Author,
+			Comment:    layer.Comment,
+			EmptyLayer: layer.ThrowAway,
+		})
+		if i == 0 {
+			config.Created = layer.Created
+			config.Author = layer.Author
+			config.Platform = ocispec.Platform{OS: layer.OS, Architecture: layer.Architecture}
+			config.Config = layer.Config
+		}
+	}
+	if config.Architecture == "" {
+		config.Architecture = manifest.Architecture
+	}
+	if config.OS == "" {
+		config.OS = "linux"
+	}
+	config.RootFS.Type = "layers"
+	return digest.FromBytes(rawManifest).String(), config, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestSchema1Config(t *testing.T) {
+	manifest := []byte(`{
+  "schemaVersion": 1,
+  "name": "library/app",
+  "tag": "1.0",
+  "architecture": "amd64",
+  "history": [
+    {"v1Compatibility": "{\"id\":\"c\",\"parent\":\"b\",\"created\":\"2016-03-01T00:00:02Z\",\"author\":\"ops@example.com\",\"os\":\"linux\",\"architecture\":\"amd64\",\"config\":{\"User\":\"app\",\"Env\":[\"PATH=/usr/bin:/bin\"],\"Cmd\":[\"/app\"],\"Labels\":{\"team\":\"ops\"}},\"container_config\":{\"Cmd\":[\"/bin/sh\",\"-c\",\"#(nop) \",\"CMD [\\\"/app\\\"]\"]},\"throwaway\":true}"},
+    {"v1Compatibility": "{\"id\":\"b\",\"parent\":\"a\",\"created\":\"2016-03-01T00:00:01Z\",\"container_config\":{\"Cmd\":[\"/bin/sh\",\"-c\",\"make install\"]}}"},
+    {"v1Compatibility": "{\"id\":\"a\",\"created\":\"2016-03-01T00:00:00Z\",\"container_config\":{\"Cmd\":[\"/bin/sh\",\"-c\",\"#(nop) ADD file:4bc7e5d in /\"]}}"}
+  ]
+}`)
+	id, config, err := schema1Config(manifest)
+	if err != nil {
+		t.Fatalf("schema1Config() error = %v", err)
+	}
+	if id != digest.FromBytes(manifest).String() {
+		t.Errorf("schema1Config() id = %s, want the digest of the manifest", id)
+	}
+
+	// The history is oldest first, like that of an image config
+	var steps []string
+	for _, event := range config.History {
+		steps = append(steps, event.CreatedBy)
+	}
+	want := []string{"/bin/sh -c #(nop) ADD file:4bc7e5d in /", "/bin/sh -c make install", `/bin/sh -c #(nop)  CMD ["/app"]`}
+	if !slices.Equal(steps, want) {
+		t.Errorf("schema1Config() history = %q, want %q", steps, want)
+	}
+	if !config.History[2].EmptyLayer || config.History[1].EmptyLayer {
+		t.Errorf("schema1Config() did not keep which steps were thrown away")
+	}
+
+	// The newest layer holds the image config
+	if config.OS != "linux" || config.Architecture != "amd64" || config.Author != "ops@example.com" {
+		t.Errorf("schema1Config() platform = %s/%s by %s, want linux/amd64 by ops@example.com", config.OS, config.Architecture, config.Author)
+	}
+	if config.Created == nil || config.Created.Second() != 2 {
+		t.Errorf("schema1Config() created = %v, want the time of the newest layer", config.Created)
+	}
+	if config.Config.User != "app" || !slices.Equal(config.Config.Cmd, []string{"/app"}) || config.Config.Labels["team"] != "ops" {
+		t.Errorf("schema1Config() config = %+v, want that of the newest layer", config.Config)
+	}
+}
+
+func TestSchema1ConfigDefaults(t *testing.T) {
+	// Old manifests leave the platform out of the layers
+	manifest := []byte(`{"schemaVersion": 1, "architecture": "arm", "history": [{"v1Compatibility": "{\"id\":\"a\"}"}]}`)
+	_, config, err := schema1Config(manifest)
+	if err != nil {
+		t.Fatalf("schema1Config() error = %v", err)
+	}
+	if config.OS != "linux" || config.Architecture != "arm" {
+		t.Errorf("schema1Config() platform = %s/%s, want linux/arm", config.OS, config.Architecture)
+	}
+}
+
+func TestSchema1ConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{
+			name:     "not JSON",
+			manifest: `schemaVersion: 1`,
+		},
+		{
+			name:     "a schema 2 manifest",
+			manifest: `{"schemaVersion": 2, "history": [{"v1Compatibility": "{}"}]}`,
+		},
+		{
+			name:     "no history",
+			manifest: `{"schemaVersion": 1, "history": []}`,
+		},
+		{
+			name:     "an invalid layer",
+			manifest: `{"schemaVersion": 1, "history": [{"v1Compatibility": "{"}]}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := schema1Config([]byte(test.manifest))
+			if err == nil {
+				t.Errorf("schema1Config() converted %s", test.manifest)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+type similarCommand struct {
+	Limit int `long:"limit" description:"Show at most this many images." default:"10"`
+	opts  *Options
+}
+
+// rankSimilar orders the comparisons by the layers shared first, since a common layer chain means
+// one image was built on the other, and by identical instructions next. Images sharing neither are
+// left out.
+func rankSimilar(pairs []similarity) (ranked []similarity) {
+	for _, pair := range pairs {
+		if pair.SharedLayers > 0 || pair.IdenticalInstructions > 0 {
+			ranked = append(ranked, pair)
+		}
+	}
+	slices.SortStableFunc(ranked, func(a, b similarity) int {
+		if a.SharedLayers != b.SharedLayers {
+			return b.SharedLayers - a.SharedLayers
+		}
+		return b.IdenticalInstructions - a.IdenticalInstructions
+	})
+	return ranked
+}
+
+// Execute reconstructs the image given with --image and every other local image, and ranks the
+// others by how similar they are to it, to find the internal base an unknown image was built from.
+func (c *similarCommand) Execute(args []string) (err error) {
+	imageNames := append(slices.Clip(c.opts.ImageNames), args...)
+	if len(imageNames) != 1 {
+		return fmt.Errorf("similar compares one image with the others - give it with --image")
+	}
+	err = requireFeature(c.opts, FEATURE_LIST, "similar compares the image with the other local images")
+	if err != nil {
+		return err
+	}
+	if c.Limit < 1 {
+		return fmt.Errorf("--limit must be at least 1")
+	}
+
+	ctx, cancel := newContext(c.opts)
+	defer cancel()
+
+	config, err := loadConfig(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+	cli, err := newBackend(c.opts, config)
+	if err != nil {
+		return err
+	}
+	imageList, err := cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to generate the list of images: %w", err)
+	}
+
+	target := namedTarget(imageNames[0])
+	myImage, err := findImageFromImageList(imageList, target.imageId, target.repoTag)
+	if err != nil {
+		return err
+	}
+	// Every other tag of the image itself is left out
+	targets := []imageTarget{target}
+	for _, img := range imageList {
+		if img.ID != myImage.ID {
+			targets = append(targets, listedTarget(img))
+		}
+	}
+	images, errs := reconstructImages(ctx, cli, imageList, targets, c.opts.Concurrency, c.opts, config)
+	if errs[0] != nil {
+		return errs[0]
+	}
+
+	var pairs []similarity
+	for i := 1; i < len(images); i++ {
+		if errs[i] != nil {
+			// An image that cannot be reconstructed is only left out of the ranking
+			fmt.Fprintf(os.Stderr, "warning: %s\n", errs[i])
+			continue
+		}
+		pairs = append(pairs, compareImages(images[0], images[i]))
+	}
+	ranked := rankSimilar(pairs)
+	if len(ranked) > c.Limit {
+		ranked = ranked[:c.Limit]
+	}
+
+	if c.opts.Format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(ranked)
+	}
+	if len(ranked) == 0 {
+		fmt.Printf("no local image shares layers or instructions with %s\n", images[0].name)
+		return nil
+	}
+	return printSimilar(os.Stdout, ranked)
+}
+
+func printSimilar(w io.Writer, ranked []similarity) (err error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tIMAGE\tSHARED LAYERS\tIDENTICAL INSTRUCTIONS")
+	for i, pair := range ranked {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d%%\n", i+1, pair.ImageB, pair.SharedLayers, pair.IdenticalInstructions)
+	}
+	return tw.Flush()
+}
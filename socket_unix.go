@@ -0,0 +1,94 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+const DEFAULT_CONTAINERD_ADDRESS = "/run/containerd/containerd.sock"
+
+// CRI_ENDPOINTS are the sockets of the common Kubernetes container runtimes
+var CRI_ENDPOINTS = []string{
+	"unix:///run/containerd/containerd.sock",
+	"unix:///run/crio/crio.sock",
+	"unix:///var/run/cri-dockerd.sock",
+}
+
+func getSocket() (socketName string, err error) {
+	user, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	socketPaths := []string{
+		filepath.Join(user.HomeDir, ".rd", "docker.sock"),
+		filepath.Join(user.HomeDir, ".docker", "run", "docker.sock"),
+		filepath.Join(user.HomeDir, ".orbstack", "run", "docker.sock"),
+	}
+
+	// Colima keeps one socket per profile, with the default profile first
+	colimaHome := os.Getenv("COLIMA_HOME")
+	if colimaHome == "" {
+		colimaHome = filepath.Join(user.HomeDir, ".colima")
+	}
+	socketPaths = append(socketPaths, filepath.Join(colimaHome, "default", "docker.sock"))
+	if profileSockets, err := filepath.Glob(filepath.Join(colimaHome, "*", "docker.sock")); err == nil {
+		socketPaths = append(socketPaths, profileSockets...)
+	}
+
+	// Rootless Docker listens in the user's runtime directory
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		socketPaths = append(socketPaths, filepath.Join(runtimeDir, "docker.sock"))
+	}
+	socketPaths = append(socketPaths,
+		filepath.Join("/run", "user", user.Uid, "docker.sock"),
+		"/var/run/docker.sock",
+	)
+
+	for _, socketPath := range socketPaths {
+		if fileExists(socketPath) {
+			return socketPath, nil
+		}
+	}
+
+	return "", errors.New("failed to find the docker socket - use --socket to specify the path to docker.sock")
+}
+
+func getPodmanSocket() (socketName string, err error) {
+	user, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	var socketPaths []string
+
+	// Rootless Podman serves the API from the user's runtime directory, rootful Podman from /run
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		socketPaths = append(socketPaths, filepath.Join(runtimeDir, "podman", "podman.sock"))
+	}
+	socketPaths = append(socketPaths,
+		filepath.Join("/run", "user", user.Uid, "podman", "podman.sock"),
+		"/run/podman/podman.sock",
+	)
+
+	// Podman machine forwards the API of its VM to the host
+	machineDir := filepath.Join(user.HomeDir, ".local", "share", "containers", "podman", "machine")
+	socketPaths = append(socketPaths, filepath.Join(machineDir, "podman.sock"))
+	if machineSockets, err := filepath.Glob(filepath.Join(machineDir, "*", "podman.sock")); err == nil {
+		socketPaths = append(socketPaths, machineSockets...)
+	}
+
+	for _, socketPath := range socketPaths {
+		if fileExists(socketPath) {
+			return socketPath, nil
+		}
+	}
+
+	return "", errors.New("failed to find the podman socket - start it with \"systemctl --user start podman.socket\" or use --socket to specify the path to podman.sock")
+}
+
+func socketHost(socketPath string) (host string) {
+	return "unix://" + socketPath
+}
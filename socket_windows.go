@@ -0,0 +1,50 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+const DEFAULT_CONTAINERD_ADDRESS = `//./pipe/containerd-containerd`
+
+// CRI_ENDPOINTS are the named pipes of the common Kubernetes container runtimes
+var CRI_ENDPOINTS = []string{
+	"npipe:////./pipe/containerd-containerd",
+	"npipe:////./pipe/cri-dockerd",
+}
+
+func getSocket() (socketName string, err error) {
+	socketPaths := []string{
+		`//./pipe/docker_engine`,
+		`//./pipe/dockerDesktopLinuxEngine`,
+	}
+
+	for _, socketPath := range socketPaths {
+		if fileExists(socketPath) {
+			return socketPath, nil
+		}
+	}
+
+	return "", errors.New("failed to find the docker named pipe - use --socket to specify the path to the pipe")
+}
+
+func getPodmanSocket() (socketName string, err error) {
+	socketPath := `//./pipe/podman-machine-default`
+	if fileExists(socketPath) {
+		return socketPath, nil
+	}
+
+	return "", errors.New("failed to find the podman named pipe - start it with \"podman machine start\" or use --socket to specify the path to the pipe")
+}
+
+// socketHost turns a --socket value into a docker host. Named pipes may be given as
+// \\.\pipe\docker_engine or //./pipe/docker_engine, anything else is treated as a unix socket.
+func socketHost(socketPath string) (host string) {
+	socketPath = strings.ReplaceAll(socketPath, `\`, "/")
+	if strings.HasPrefix(socketPath, "//./pipe/") {
+		return "npipe://" + socketPath
+	}
+	return "unix://" + socketPath
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// The phases --stats reports the time of
+const (
+	PHASE_IMAGE_LIST     = "image list"
+	PHASE_LAYER_INDEXING = "layer indexing"
+	PHASE_HISTORY_PARSE  = "history parse"
+	PHASE_RENDER         = "render"
+)
+
+// The daemon API calls --stats counts
+const (
+	CALL_LIST    = "list"
+	CALL_INSPECT = "inspect"
+	CALL_HISTORY = "history"
+)
+
+// runStats adds up how long every phase took and the daemon API calls made, over every image of
+// the run.
+type runStats struct {
+	mu     sync.Mutex
+	start  time.Time
+	phases map[string]time.Duration
+	calls  map[string]int
+}
+
+// STATS is collected on every run and printed with --stats.
+var STATS = &runStats{start: time.Now(), phases: map[string]time.Duration{}, calls: map[string]int{}}
+
+// time starts timing a phase and returns the function that ends it.
+func (s *runStats) time(phase string) (done func()) {
+	start := time.Now()
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.phases[phase] += time.Since(start)
+	}
+}
+
+// call counts a daemon API call, including every retry.
+func (s *runStats) call(call string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[call]++
+}
+
+// print writes the report. The phases of images inspected at the same time overlap, so their sum
+// can exceed the total.
+func (s *runStats) print(w io.Writer) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tDURATION")
+	for _, phase := range []string{PHASE_IMAGE_LIST, PHASE_LAYER_INDEXING, PHASE_HISTORY_PARSE, PHASE_RENDER} {
+		fmt.Fprintf(tw, "%s\t%s\n", phase, s.phases[phase].Round(time.Millisecond))
+	}
+	fmt.Fprintf(tw, "total\t%s\n", time.Since(s.start).Round(time.Millisecond))
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	// Only daemons are called, the other image sources are read up front
+	var total int
+	var calls []string
+	for _, call := range []string{CALL_LIST, CALL_INSPECT, CALL_HISTORY} {
+		total += s.calls[call]
+		calls = append(calls, fmt.Sprintf("%d %s", s.calls[call], call))
+	}
+	if total == 0 {
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "\n%d daemon API calls (%s)\n", total, strings.Join(calls, ", "))
+	return err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// EXIT_PARTIAL_FAILURE is the exit status of a batch in which some images could not be inspected.
+const EXIT_PARTIAL_FAILURE = 4
+
+// imageSummary is the outcome of inspecting one image of a batch.
+type imageSummary struct {
+	Image        string
+	BaseImage    string
+	Instructions int
+	Warnings     int
+	Duration     time.Duration
+	PolicyFailed bool
+	// Unchanged marks an image skipped because it has not changed since the last run, which is
+	// summarized as that run reported it
+	Unchanged bool
+	// Error is why the image could not be inspected, if it could not
+	Error error
+}
+
+func (s imageSummary) status() string {
+	if s.Error != nil {
+		return "failed"
+	}
+	status := "ok"
+	if s.PolicyFailed {
+		status = "policy failed"
+	}
+	if s.Unchanged {
+		status += ", unchanged"
+	}
+	return status
+}
+
+// printBatchSummary prints a table of the images inspected in one run, so a batch shows at a glance
+// which images have no base image or raised warnings, followed by the errors of the images that
+// could not be inspected.
+func printBatchSummary(w io.Writer, summaries []imageSummary) (err error) {
+	var failed, unchanged, errors int
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tBASE IMAGE\tINSTRUCTIONS\tWARNINGS\tDURATION\tSTATUS")
+	for _, s := range summaries {
+		baseImage := s.BaseImage
+		if baseImage == "" {
+			baseImage = "-"
+		}
+		if s.Error != nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t%s\n", s.Image, s.status())
+			errors++
+			continue
+		}
+		duration := s.Duration.Round(time.Millisecond).String()
+		if s.Unchanged {
+			duration = "-"
+			unchanged++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", s.Image, baseImage, s.Instructions, s.Warnings, duration, s.status())
+		if s.PolicyFailed {
+			failed++
+		}
+	}
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "\n%d images inspected, %d unchanged since the last run, %d failed the policy, %d could not be inspected\n", len(summaries), unchanged, failed, errors)
+	if err != nil || errors == 0 {
+		return err
+	}
+	fmt.Fprintln(w, "\nErrors:")
+	for _, s := range summaries {
+		if s.Error != nil {
+			fmt.Fprintf(w, "  %s: %s\n", s.Image, s.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+// SYNC_MANIFEST lists the files sync wrote to a directory, the only ones it ever removes from it.
+const SYNC_MANIFEST = ".dfimage-sync.json"
+
+type syncCommand struct {
+	OutDir string `long:"outdir" description:"Mirror the images to this directory, one file each." required:"yes"`
+	opts   *Options
+}
+
+// syncManifest is the content of SYNC_MANIFEST.
+type syncManifest struct {
+	// Files are relative to the directory
+	Files []string `json:"files"`
+}
+
+func readSyncManifest(dir string) (manifest syncManifest, err error) {
+	contents, err := os.ReadFile(filepath.Join(dir, SYNC_MANIFEST))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(contents, &manifest)
+	if err != nil {
+		return manifest, fmt.Errorf("unable to parse %s: %w", filepath.Join(dir, SYNC_MANIFEST), err)
+	}
+	return manifest, nil
+}
+
+func writeSyncManifest(dir string, manifest syncManifest) (err error) {
+	slices.Sort(manifest.Files)
+	contents, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(filepath.Join(dir, SYNC_MANIFEST), append(contents, '\n'))
+}
+
+// removeSyncedFile removes a file sync wrote before, and the subdirectories of dir it leaves empty.
+func removeSyncedFile(dir string, relative string) (err error) {
+	err = os.Remove(filepath.Join(dir, relative))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for parent := filepath.Dir(relative); parent != "."; parent = filepath.Dir(parent) {
+		// Directories that are not empty are kept
+		if os.Remove(filepath.Join(dir, parent)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Execute makes the directory mirror the images on the daemon, or those selected with --match or
+// --match-re. Files of new and changed images are written, the files of images that are gone are
+// removed and the other files are left untouched, so sync can be run periodically.
+func (c *syncCommand) Execute(args []string) (err error) {
+	err = requireFeature(c.opts, FEATURE_LIST, "sync mirrors every image of a daemon or an archive")
+	if err != nil {
+		return err
+	}
+	if len(c.opts.ImageNames) > 0 || len(args) > 0 {
+		return fmt.Errorf("sync mirrors every image - select them with --match or --match-re instead")
+	}
+	for _, expr := range c.opts.MatchRe {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("--match-re %s is not a valid regular expression: %w", expr, err)
+		}
+	}
+	filenameTemplate, err := parseFilenameTemplate(c.opts.FilenameTemplate, c.opts.Format)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(c.OutDir, 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create the output directory: %w", err)
+	}
+	manifest, err := readSyncManifest(c.OutDir)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := newContext(c.opts)
+	defer cancel()
+
+	config, err := loadConfig(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+	cli, err := newBackend(c.opts, config)
+	if err != nil {
+		return err
+	}
+	imageList, err := cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to generate the list of images: %w", err)
+	}
+
+	var targets []imageTarget
+	if len(c.opts.Match) > 0 || len(c.opts.MatchRe) > 0 {
+		targets = matchingTargets(imageList, c.opts.Match, c.opts.MatchRe)
+	} else {
+		for _, img := range imageList {
+			targets = append(targets, listedTarget(img))
+		}
+	}
+	// The daemon lists images in an order that varies between runs
+	slices.SortStableFunc(targets, func(a, b imageTarget) int {
+		return strings.Compare(a.repoTag, b.repoTag)
+	})
+
+	// Every file is named before any image is reconstructed, so clashes leave the directory as it was
+	files := make([]string, len(targets))
+	written := map[string]string{}
+	for i, target := range targets {
+		path, err := outputPath(c.OutDir, filenameTemplate, target.repoTag)
+		if err != nil {
+			return err
+		}
+		files[i], _ = filepath.Rel(c.OutDir, path)
+		if other, ok := written[files[i]]; ok {
+			return fmt.Errorf("the images %s and %s would both be written to %s - use a --filename-template that tells them apart", other, target.repoTag, path)
+		}
+		written[files[i]] = target.repoTag
+	}
+
+	images, errs := reconstructImages(ctx, cli, imageList, targets, c.opts.Concurrency, c.opts, config)
+	// An interrupted run leaves the directory as it was
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	var created, updated, removed, unchanged, failed int
+	var synced []string
+	for i, img := range images {
+		path := filepath.Join(c.OutDir, files[i])
+		if errs[i] != nil {
+			// The file of an image that cannot be reconstructed is kept until it can
+			fmt.Fprintf(os.Stderr, "warning: %s: %s\n", targets[i].repoTag, errs[i])
+			failed++
+			if slices.Contains(manifest.Files, files[i]) {
+				synced = append(synced, files[i])
+			}
+			continue
+		}
+		synced = append(synced, files[i])
+
+		output, err := renderOutput(c.opts.Format, newJsonDocument(img.result, c.opts.Deterministic), config)
+		if err != nil {
+			return err
+		}
+		existing, err := os.ReadFile(path)
+		switch {
+		case err == nil && slices.Equal(normalizeOutput(string(existing)), normalizeOutput(output)):
+			unchanged++
+			continue
+		case err == nil:
+			fmt.Printf("updated %s\n", path)
+			updated++
+		case errors.Is(err, os.ErrNotExist):
+			fmt.Printf("created %s\n", path)
+			created++
+		default:
+			return err
+		}
+		err = os.WriteFile(path, []byte(output), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only the files sync wrote itself are removed, never other files in the directory
+	for _, file := range manifest.Files {
+		if slices.Contains(synced, file) {
+			continue
+		}
+		err = removeSyncedFile(c.OutDir, file)
+		if err != nil {
+			return fmt.Errorf("unable to remove %s: %w", filepath.Join(c.OutDir, file), err)
+		}
+		fmt.Printf("removed %s\n", filepath.Join(c.OutDir, file))
+		removed++
+	}
+	err = writeSyncManifest(c.OutDir, syncManifest{Files: synced})
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", filepath.Join(c.OutDir, SYNC_MANIFEST), err)
+	}
+
+	fmt.Printf("Synced %d images to %s: %d created, %d updated, %d removed, %d unchanged.\n", len(targets)-failed, c.OutDir, created, updated, removed, unchanged)
+	if failed > 0 {
+		return fmt.Errorf("%d images could not be reconstructed - their files were left as they were", failed)
+	}
+	return nil
+}
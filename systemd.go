@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// systemdQuote quotes a word for a unit file, which splits on whitespace and expands % specifiers.
+func systemdQuote(word string) (quoted string) {
+	word = strings.ReplaceAll(word, "%", "%%")
+	if word != "" && !strings.ContainsAny(word, " \t\n\"'\\") {
+		return word
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	return `"` + replacer.Replace(word) + `"`
+}
+
+func systemdJoin(words []string) (line string) {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = systemdQuote(word)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// renderSystemd writes a Podman quadlet that runs the image as a systemd service the way its config
+// would run it. Podman turns it into a unit when it is saved as a .container file under
+// /etc/containers/systemd or ~/.config/containers/systemd.
+func renderSystemd(document jsonDocument) (output string, err error) {
+	config := document.Config
+	name := k8sName(document.Image)
+
+	var notes []string
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s\n", systemdQuote(document.Image))
+	b.WriteString("\n[Container]\n")
+	fmt.Fprintf(&b, "Image=%s\n", document.Image)
+	fmt.Fprintf(&b, "ContainerName=%s\n", name)
+	if len(config.Entrypoint) > 0 {
+		// An entrypoint is a single string in a quadlet, so one with arguments needs a shell or Exec
+		if len(config.Entrypoint) == 1 {
+			fmt.Fprintf(&b, "Entrypoint=%s\n", systemdQuote(config.Entrypoint[0]))
+		} else {
+			notes = append(notes, fmt.Sprintf("the image entrypoint is %s - it is left to the image since a quadlet Entrypoint takes a single path", systemdJoin(config.Entrypoint)))
+		}
+	}
+	if len(config.Cmd) > 0 {
+		fmt.Fprintf(&b, "Exec=%s\n", systemdJoin(config.Cmd))
+	}
+	if config.WorkingDir != "" {
+		fmt.Fprintf(&b, "WorkingDir=%s\n", systemdQuote(config.WorkingDir))
+	}
+	// The image defaults are listed so they can be overridden, except PATH which rarely should be
+	for _, variable := range config.Env {
+		if key, _, _ := strings.Cut(variable, "="); key != "PATH" {
+			fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(variable))
+		}
+	}
+	for _, port := range config.ExposedPorts {
+		number, protocol, _ := strings.Cut(port, "/")
+		if _, err := strconv.Atoi(number); err != nil {
+			continue
+		}
+		if protocol == "" || protocol == "tcp" {
+			fmt.Fprintf(&b, "PublishPort=%s:%s\n", number, number)
+		} else {
+			fmt.Fprintf(&b, "PublishPort=%s:%s/%s\n", number, number, protocol)
+		}
+	}
+	for i, volume := range config.Volumes {
+		fmt.Fprintf(&b, "Volume=%s-volume-%d:%s\n", name, i+1, systemdQuote(volume))
+	}
+	if len(config.ExposedPorts) > 0 {
+		notes = append(notes, "every exposed port is published on the same host port - change the host side where it clashes")
+	}
+	if isRootUser(config.User) {
+		notes = append(notes, "the image runs as root - set User= to a non-root user if the image supports it, or run the quadlet rootless")
+	}
+	b.WriteString("\n[Service]\nRestart=always\n")
+	b.WriteString("\n[Install]\nWantedBy=default.target\n")
+
+	var header strings.Builder
+	fmt.Fprintf(&header, "# Generated by dfimage %s from %s\n", document.DfimageVersion, document.Image)
+	fmt.Fprintf(&header, "# Save as %s.container in /etc/containers/systemd or ~/.config/containers/systemd\n", name)
+	header.WriteString(renderWarnings(document.Warnings))
+	for _, note := range notes {
+		fmt.Fprintf(&header, "# Note: %s\n", note)
+	}
+	return header.String() + b.String(), nil
+}
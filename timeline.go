@@ -0,0 +1,222 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	"github.com/opencontainers/go-digest"
+)
+
+type timelineCommand struct {
+	Digests []string `long:"digest" description:"A digest the tag pointed to, oldest first. Can be repeated; required with --remote."`
+	Args    struct {
+		Image string `positional-arg-name:"image" description:"The repository and tag to follow."`
+	} `positional-args:"yes" required:"yes"`
+	opts *Options
+}
+
+// timelineEntry is one version of the tag, with what changed since the version before it.
+type timelineEntry struct {
+	Image        string   `json:"image"`
+	ImageID      string   `json:"image_id"`
+	Created      string   `json:"created"`
+	BaseImage    string   `json:"base_image,omitempty"`
+	Instructions []string `json:"instructions"`
+	// Changes are the instructions removed (-) and added (+) since the previous entry
+	Changes []string `json:"changes,omitempty"`
+}
+
+// diffLines lists the lines of a missing from b prefixed with "- " and those of b missing from a
+// with "+ ", in the order of a longest common subsequence.
+func diffLines(a []string, b []string) (changes []string) {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			changes = append(changes, "- "+a[i])
+			i++
+		default:
+			changes = append(changes, "+ "+b[j])
+			j++
+		}
+	}
+	return changes
+}
+
+// Execute reconstructs every version of a tag, oldest first, and prints what changed between
+// consecutive versions. Registries keep no history of a tag, so the earlier versions are either
+// given with --digest or, locally, are the images pulled from the repository before.
+func (c *timelineCommand) Execute(args []string) (err error) {
+	named, err := reference.ParseNormalizedNamed(c.Args.Image)
+	if err != nil {
+		return fmt.Errorf("invalid image name %s: %w", c.Args.Image, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return fmt.Errorf("timeline follows a tag - give the image as repository:tag and the digests with --digest")
+	}
+	named = reference.TagNameOnly(named)
+	repository := reference.TrimNamed(named)
+
+	var imageNames []string
+	for _, d := range c.Digests {
+		parsed, err := digest.Parse(d)
+		if err != nil {
+			return fmt.Errorf("invalid digest %s: %w", d, err)
+		}
+		withDigest, err := reference.WithDigest(repository, parsed)
+		if err != nil {
+			return err
+		}
+		imageNames = append(imageNames, reference.FamiliarString(withDigest))
+	}
+	// Without --digest, the earlier versions are the images pulled from the repository before
+	if len(imageNames) == 0 {
+		for _, feature := range []string{FEATURE_LIST, FEATURE_REPO_DIGESTS} {
+			err = requireFeature(c.opts, feature, "list the digests the tag pointed to with --digest")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// The current version of the tag comes last
+	imageNames = append(imageNames, reference.FamiliarString(named))
+
+	ctx, cancel := newContext(c.opts)
+	defer cancel()
+
+	config, err := loadConfig(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+	// Remote mode only fetches the images it is given
+	c.opts.ImageNames = imageNames
+	cli, err := newBackend(c.opts, config)
+	if err != nil {
+		return err
+	}
+	imageList, err := cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to generate the list of images: %w", err)
+	}
+	if len(c.Digests) == 0 {
+		target := namedTarget(imageNames[0])
+		current, err := findImageFromImageList(imageList, target.imageId, target.repoTag)
+		if err != nil {
+			return err
+		}
+		imageNames = append(pulledVersions(imageList, repository, current.ID), imageNames...)
+	}
+
+	var targets []imageTarget
+	for _, imageName := range imageNames {
+		targets = append(targets, namedTarget(imageName))
+	}
+	images, errs := reconstructImages(ctx, cli, imageList, targets, c.opts.Concurrency, c.opts, config)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	var entries []timelineEntry
+	for _, img := range images {
+		// A digest listed twice, or the tag still pointing to the last digest, is not a new version
+		if len(entries) > 0 && entries[len(entries)-1].ImageID == img.id {
+			continue
+		}
+		entry := timelineEntry{
+			Image:        img.name,
+			ImageID:      img.id,
+			Created:      img.result.Created,
+			BaseImage:    img.result.BaseImage,
+			Instructions: img.instructions,
+		}
+		if len(entries) > 0 {
+			entry.Changes = diffLines(entries[len(entries)-1].Instructions, entry.Instructions)
+		}
+		entries = append(entries, entry)
+	}
+
+	if c.opts.Format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+	printTimeline(os.Stdout, entries)
+	return nil
+}
+
+// pulledVersions finds the earlier versions of a repository on the daemon, which keeps the
+// repository digest of an image after its tag has moved on, oldest first.
+func pulledVersions(imageList []image.Summary, repository reference.Named, currentId string) (imageNames []string) {
+	var versions []image.Summary
+	for _, img := range imageList {
+		if img.ID != currentId && findRepoDigest(img, repository) != "" {
+			versions = append(versions, img)
+		}
+	}
+	slices.SortStableFunc(versions, func(a, b image.Summary) int {
+		return cmp.Compare(a.Created, b.Created)
+	})
+	for _, img := range versions {
+		imageNames = append(imageNames, findRepoDigest(img, repository))
+	}
+	return imageNames
+}
+
+// findRepoDigest returns the repository digest an image was pulled from the repository with, if any.
+func findRepoDigest(img image.Summary, repository reference.Named) string {
+	for _, repoDigest := range img.RepoDigests {
+		named, err := reference.ParseNormalizedNamed(repoDigest)
+		if err == nil && named.Name() == repository.Name() {
+			return reference.FamiliarString(named)
+		}
+	}
+	return ""
+}
+
+func printTimeline(w io.Writer, entries []timelineEntry) {
+	for i, entry := range entries {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "[%d] %s\n", i+1, entry.Image)
+		fmt.Fprintf(w, "    image ID %s, created %s\n", shortImageId(entry.ImageID), entry.Created)
+		if i == 0 {
+			fmt.Fprintln(w, strings.Join(entry.Instructions, "\n"))
+			continue
+		}
+		if entry.BaseImage != entries[i-1].BaseImage {
+			fmt.Fprintf(w, "    base image %s -> %s\n", entries[i-1].BaseImage, entry.BaseImage)
+		}
+		if len(entry.Changes) == 0 {
+			fmt.Fprintln(w, "no instruction changed")
+			continue
+		}
+		fmt.Fprintln(w, strings.Join(entry.Changes, "\n"))
+	}
+}